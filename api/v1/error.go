@@ -2,6 +2,7 @@ package log_v1
 
 import (
 	"fmt"
+	"strconv"
 
 	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc/codes"
@@ -10,6 +11,16 @@ import (
 
 type ErrOffsetOutOfRange struct {
 	Offset uint64
+	// Lowest and Highest are the offsets of the first and last records in the
+	// log when the error happened, so clients can recover from it.
+	Lowest  uint64
+	Highest uint64
+}
+
+// Truncated reports whether the offset was below the lowest one in the log,
+// meaning it was removed, rather than not appended yet.
+func (e ErrOffsetOutOfRange) Truncated() bool {
+	return e.Offset < e.Lowest
 }
 
 func (e ErrOffsetOutOfRange) GRPCStatus() *status.Status {
@@ -17,16 +28,27 @@ func (e ErrOffsetOutOfRange) GRPCStatus() *status.Status {
 		codes.NotFound,
 		fmt.Sprintf("offset out of range %d", e.Offset),
 	)
-	msg := fmt.Sprintf("The requested offset is outside the log's range: %d",
+	msg := fmt.Sprintf("The requested offset is outside the log's range: %d, the log holds offsets %d to %d",
 		e.Offset,
+		e.Lowest,
+		e.Highest,
 	)
 
 	d := &errdetails.LocalizedMessage{
 		Locale:  "en-US",
 		Message: msg,
 	}
+	info := &errdetails.ErrorInfo{
+		Reason: "OFFSET_OUT_OF_RANGE",
+		Domain: "proglog",
+		Metadata: map[string]string{
+			"offset":  strconv.FormatUint(e.Offset, 10),
+			"lowest":  strconv.FormatUint(e.Lowest, 10),
+			"highest": strconv.FormatUint(e.Highest, 10),
+		},
+	}
 
-	std, err := st.WithDetails(d)
+	std, err := st.WithDetails(d, info)
 	if err != nil {
 		return st
 	}
@@ -36,3 +58,208 @@ func (e ErrOffsetOutOfRange) GRPCStatus() *status.Status {
 func (e ErrOffsetOutOfRange) Error() string {
 	return e.GRPCStatus().Err().Error()
 }
+
+// ErrOffsetConflict is returned by a conditional append when the log's next
+// offset isn't the expected one, because another writer appended first.
+type ErrOffsetConflict struct {
+	Expected uint64
+	// Next is the offset the record would have been appended at.
+	Next uint64
+}
+
+func (e ErrOffsetConflict) GRPCStatus() *status.Status {
+	st := status.New(
+		codes.FailedPrecondition,
+		fmt.Sprintf("offset conflict: expected %d, next is %d", e.Expected, e.Next),
+	)
+	msg := fmt.Sprintf("The record was expected at offset %d but the log's next offset is %d",
+		e.Expected,
+		e.Next,
+	)
+
+	d := &errdetails.LocalizedMessage{
+		Locale:  "en-US",
+		Message: msg,
+	}
+	info := &errdetails.ErrorInfo{
+		Reason: "OFFSET_CONFLICT",
+		Domain: "proglog",
+		Metadata: map[string]string{
+			"expected": strconv.FormatUint(e.Expected, 10),
+			"next":     strconv.FormatUint(e.Next, 10),
+		},
+	}
+
+	std, err := st.WithDetails(d, info)
+	if err != nil {
+		return st
+	}
+	return std
+}
+
+func (e ErrOffsetConflict) Error() string {
+	return e.GRPCStatus().Err().Error()
+}
+
+// ErrUnknownProducer is returned when a record is produced with a producer id
+// the log didn't register, or whose state it lost. The producer has to
+// register again.
+type ErrUnknownProducer struct {
+	ProducerID uint64
+}
+
+func (e ErrUnknownProducer) GRPCStatus() *status.Status {
+	st := status.New(
+		codes.FailedPrecondition,
+		fmt.Sprintf("unknown producer %d", e.ProducerID),
+	)
+	msg := fmt.Sprintf("The producer %d isn't registered with the log, register again to get a new id",
+		e.ProducerID,
+	)
+
+	d := &errdetails.LocalizedMessage{
+		Locale:  "en-US",
+		Message: msg,
+	}
+	info := &errdetails.ErrorInfo{
+		Reason: "UNKNOWN_PRODUCER",
+		Domain: "proglog",
+		Metadata: map[string]string{
+			"producer_id": strconv.FormatUint(e.ProducerID, 10),
+		},
+	}
+
+	std, err := st.WithDetails(d, info)
+	if err != nil {
+		return st
+	}
+	return std
+}
+
+func (e ErrUnknownProducer) Error() string {
+	return e.GRPCStatus().Err().Error()
+}
+
+// ErrOutOfOrderSequence is returned when a producer sends a sequence number
+// that's neither the next one nor a retry of one of the last ones it sent.
+type ErrOutOfOrderSequence struct {
+	ProducerID uint64
+	// Expected is the next sequence number of the producer.
+	Expected uint64
+	Sequence uint64
+}
+
+func (e ErrOutOfOrderSequence) GRPCStatus() *status.Status {
+	st := status.New(
+		codes.FailedPrecondition,
+		fmt.Sprintf("out of order sequence %d for producer %d", e.Sequence, e.ProducerID),
+	)
+	msg := fmt.Sprintf("The producer %d sent sequence %d but the next one is %d",
+		e.ProducerID,
+		e.Sequence,
+		e.Expected,
+	)
+
+	d := &errdetails.LocalizedMessage{
+		Locale:  "en-US",
+		Message: msg,
+	}
+	info := &errdetails.ErrorInfo{
+		Reason: "OUT_OF_ORDER_SEQUENCE",
+		Domain: "proglog",
+		Metadata: map[string]string{
+			"producer_id": strconv.FormatUint(e.ProducerID, 10),
+			"expected":    strconv.FormatUint(e.Expected, 10),
+			"sequence":    strconv.FormatUint(e.Sequence, 10),
+		},
+	}
+
+	std, err := st.WithDetails(d, info)
+	if err != nil {
+		return st
+	}
+	return std
+}
+
+func (e ErrOutOfOrderSequence) Error() string {
+	return e.GRPCStatus().Err().Error()
+}
+
+type ErrCorruptRecord struct {
+	// Segment is the base offset of the segment that holds the record.
+	Segment uint64
+	Offset  uint64
+}
+
+func (e ErrCorruptRecord) GRPCStatus() *status.Status {
+	st := status.New(
+		codes.DataLoss,
+		fmt.Sprintf("corrupt record at offset %d in segment %d", e.Offset, e.Segment),
+	)
+	msg := fmt.Sprintf("The record at offset %d in segment %d failed its integrity check",
+		e.Offset,
+		e.Segment,
+	)
+
+	d := &errdetails.LocalizedMessage{
+		Locale:  "en-US",
+		Message: msg,
+	}
+
+	std, err := st.WithDetails(d)
+	if err != nil {
+		return st
+	}
+	return std
+}
+
+func (e ErrCorruptRecord) Error() string {
+	return e.GRPCStatus().Err().Error()
+}
+
+// ErrPartialBatch is returned when a batch fails partway. The first Count
+// records of the batch were appended from BaseOffset on, the rest weren't.
+type ErrPartialBatch struct {
+	BaseOffset uint64
+	Count      uint64
+	// Err is why the rest of the batch wasn't appended.
+	Err error
+}
+
+func (e ErrPartialBatch) GRPCStatus() *status.Status {
+	st := status.New(
+		codes.Internal,
+		fmt.Sprintf("batch failed after %d records: %v", e.Count, e.Err),
+	)
+	msg := fmt.Sprintf("The first %d records of the batch were appended starting at offset %d, the rest weren't",
+		e.Count,
+		e.BaseOffset,
+	)
+
+	d := &errdetails.LocalizedMessage{
+		Locale:  "en-US",
+		Message: msg,
+	}
+	info := &errdetails.ErrorInfo{
+		Reason: "PARTIAL_BATCH",
+		Domain: "proglog",
+		Metadata: map[string]string{
+			"base_offset": strconv.FormatUint(e.BaseOffset, 10),
+			"count":       strconv.FormatUint(e.Count, 10),
+		},
+	}
+
+	std, err := st.WithDetails(d, info)
+	if err != nil {
+		return st
+	}
+	return std
+}
+
+func (e ErrPartialBatch) Error() string {
+	return e.GRPCStatus().Err().Error()
+}
+
+func (e ErrPartialBatch) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,130 @@
+package log
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+)
+
+// maxCodecID is the first id that can't be used by a codec. The first byte of a
+// marshalled record is the tag of its first field, which never has the field
+// number 0, so it's always greater or equal to this value. That way a record
+// written without a codec can't be confused with an encoded one.
+const maxCodecID = 8
+
+// Codec compresses the records written to a segment's store.
+type Codec interface {
+	// ID identifies the codec in every record it encodes, it must be between 1 and 7.
+	ID() byte
+	Encode(p []byte) ([]byte, error)
+	Decode(p []byte) ([]byte, error)
+}
+
+var (
+	// Gzip compresses records with the gzip format.
+	Gzip Codec = gzipCodec{}
+	// Flate compresses records with the raw DEFLATE format, it has less overhead than gzip.
+	Flate Codec = flateCodec{}
+
+	// codecs holds every codec that can be used to decode records, by id.
+	codecs = map[byte]Codec{
+		Gzip.ID():  Gzip,
+		Flate.ID(): Flate,
+	}
+)
+
+// RegisterCodec makes a codec available to decode records. It's not safe to call
+// concurrently with reads so it should be called before any log is created.
+func RegisterCodec(c Codec) error {
+	id := c.ID()
+	if id == 0 || id >= maxCodecID {
+		return fmt.Errorf("codec id %d is not between 1 and %d", id, maxCodecID-1)
+	}
+	if _, ok := codecs[id]; ok {
+		return fmt.Errorf("codec id %d is already registered", id)
+	}
+	codecs[id] = c
+	return nil
+}
+
+// encode encodes p with the given codec and prefixes it with the codec's id so
+// the record can be decoded no matter what codec the segment is configured with.
+// p is returned as is if c is nil.
+func encode(c Codec, p []byte) ([]byte, error) {
+	if c == nil {
+		return p, nil
+	}
+	b, err := c.Encode(p)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte{c.ID()}, b...), nil
+}
+
+// decode reverses encode with the codec whose id prefixes p.
+func decode(p []byte) ([]byte, error) {
+	if len(p) == 0 || p[0] >= maxCodecID {
+		return p, nil
+	}
+	c, ok := codecs[p[0]]
+	if !ok {
+		return nil, fmt.Errorf("unknown codec id %d", p[0])
+	}
+	return c.Decode(p[1:])
+}
+
+type gzipCodec struct{}
+
+func (gzipCodec) ID() byte {
+	return 1
+}
+
+func (gzipCodec) Encode(p []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(p); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gzipCodec) Decode(p []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(p))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+type flateCodec struct{}
+
+func (flateCodec) ID() byte {
+	return 2
+}
+
+func (flateCodec) Encode(p []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := flate.NewWriter(&buf, flate.DefaultCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(p); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (flateCodec) Decode(p []byte) ([]byte, error) {
+	r := flate.NewReader(bytes.NewReader(p))
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
@@ -0,0 +1,102 @@
+package log
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	api "github.com/AYM1607/proglog/api/v1"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestCodecs(t *testing.T) {
+	p := bytes.Repeat([]byte(`{"event":"page_view","path":"/"}`), 32)
+
+	for name, c := range map[string]Codec{
+		"gzip":  Gzip,
+		"flate": Flate,
+	} {
+		t.Run(name, func(t *testing.T) {
+			b, err := encode(c, p)
+			require.NoError(t, err)
+			require.Equal(t, c.ID(), b[0], "Encoded records are prefixed with the codec id.")
+			require.Less(t, len(b), len(p), "Repetitive data should be compressed.")
+
+			got, err := decode(b)
+			require.NoError(t, err)
+			require.Equal(t, p, got)
+		})
+	}
+
+	// Records written without a codec are decoded as is.
+	record, err := proto.Marshal(&api.Record{Value: p, Offset: 1})
+	require.NoError(t, err)
+	b, err := encode(nil, record)
+	require.NoError(t, err)
+	require.Equal(t, record, b)
+	got, err := decode(b)
+	require.NoError(t, err)
+	require.Equal(t, record, got)
+
+	_, err = decode([]byte{7, 1, 2, 3})
+	require.Error(t, err, "Records encoded with an unknown codec can't be decoded.")
+
+	require.Error(t, RegisterCodec(Gzip), "Codec ids can't be registered twice.")
+	require.Error(t, RegisterCodec(testCodec{id: 0}), "Codec ids must not be 0.")
+	require.Error(t, RegisterCodec(testCodec{id: maxCodecID}), "Codec ids must not look like a record.")
+}
+
+func TestSegmentCodecs(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "segment-codecs-test")
+	defer os.RemoveAll(dir)
+
+	want := &api.Record{Value: bytes.Repeat([]byte("hello world "), 32)}
+
+	c := Config{}
+	c.Segment.MaxIndexBytes = 1024
+	c.Segment.MaxStoreBytes = 4096
+
+	// Reopen the segment with a different codec for every record.
+	var size uint64
+	for _, codec := range []Codec{nil, Gzip, Flate} {
+		c.Segment.Codec = codec
+		s, err := newSegment(dir, 0, c)
+		require.NoError(t, err)
+		_, err = s.Append(want)
+		require.NoError(t, err)
+		if codec != nil {
+			require.Less(t, s.store.size-size, size/2, "Compressed records should take less space.")
+		}
+		size = s.store.size
+		require.NoError(t, s.Close())
+	}
+
+	c.Segment.Codec = nil
+	s, err := newSegment(dir, 0, c)
+	require.NoError(t, err)
+	for off := uint64(0); off < 3; off++ {
+		got, err := s.Read(off)
+		require.NoError(t, err, "Records written with any codec should be readable.")
+		require.Equal(t, want.Value, got.Value)
+		require.Equal(t, off, got.Offset)
+	}
+	require.NoError(t, s.Remove())
+}
+
+type testCodec struct {
+	id byte
+}
+
+func (c testCodec) ID() byte {
+	return c.id
+}
+
+func (testCodec) Encode(p []byte) ([]byte, error) {
+	return p, nil
+}
+
+func (testCodec) Decode(p []byte) ([]byte, error) {
+	return p, nil
+}
@@ -0,0 +1,224 @@
+package log
+
+import (
+	"os"
+	"path"
+	"time"
+
+	api "github.com/AYM1607/proglog/api/v1"
+	"go.uber.org/zap"
+)
+
+// compactDir is the directory, inside the log's, where segments are rewritten during compaction.
+const compactDir = ".compact"
+
+// Compact rewrites the sealed segments so only the latest record for each key
+// survives. Records without a key are always kept and records with a key but
+// no value, tombstones, are removed once they're older than the configured
+// retention. Offsets are preserved so reading a removed offset returns the next
+// surviving record. Segments with no records left are removed. Segments with a
+// corrupt record are left as they are, the rest are compacted anyway and the
+// first api.ErrCorruptRecord found is returned.
+func (l *Log) Compact() error {
+	if l.Config.ReadOnly {
+		return ErrReadOnly
+	}
+	l.compactMu.Lock()
+	defer l.compactMu.Unlock()
+
+	l.mu.RLock()
+	sealed := make([]*segment, len(l.segments)-1)
+	copy(sealed, l.segments)
+	l.mu.RUnlock()
+
+	// Find the latest offset of every key. The keys after a corrupt record
+	// are missed, which only keeps more records than needed.
+	var corrupt error
+	latest := make(map[string]uint64)
+	for _, s := range sealed {
+		err := l.withSegment(s, func(s *segment) error {
+			return s.scan(func(record *api.Record) error {
+				if record.Key != nil {
+					latest[string(record.Key)] = record.Offset
+				}
+				return nil
+			})
+		})
+		if _, ok := err.(api.ErrCorruptRecord); ok {
+			if corrupt == nil {
+				corrupt = err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	tombstoneDeadline := time.Now().Add(-l.Config.Compaction.TombstoneRetention).UnixNano()
+	keep := func(record *api.Record) bool {
+		if record.Key == nil {
+			return true
+		}
+		if latest[string(record.Key)] != record.Offset {
+			return false
+		}
+		return len(record.Value) > 0 || record.AppendTime > tombstoneDeadline
+	}
+
+	for _, s := range sealed {
+		err := l.compactSegment(s, keep)
+		if _, ok := err.(api.ErrCorruptRecord); ok {
+			if corrupt == nil {
+				corrupt = err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return corrupt
+}
+
+// withSegment calls fn with the segment of the log that has the base offset of
+// s, as long as there's still one. fn holds a reference to the segment instead
+// of the log's lock, so appends aren't held off however long it takes. If its
+// files were closed, they're opened for the duration of fn.
+func (l *Log) withSegment(s *segment, fn func(s *segment) error) error {
+	l.mu.RLock()
+	var cur *segment
+	for _, seg := range l.segments {
+		if seg.baseOffset == s.baseOffset {
+			cur = seg
+		}
+	}
+	l.mu.RUnlock()
+	if cur == nil {
+		return nil
+	}
+
+	cur, err := l.open(cur)
+	// The segment left the log in the meantime.
+	if err == errRetired {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer l.release(cur)
+	return fn(cur)
+}
+
+// compactSegment rewrites s with only the records for which keep returns true
+// and swaps the result in place of s.
+func (l *Log) compactSegment(s *segment, keep func(*api.Record) bool) error {
+	dir := path.Join(l.Dir, compactDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	// Sealed segments don't change so they can be rewritten while holding a
+	// reference to them, the lock is only taken to swap the result in.
+	var total, kept int
+	if err := l.withSegment(s, func(s *segment) error {
+		cleaned, err := newSegment(dir, s.baseOffset, l.Config)
+		if err != nil {
+			return err
+		}
+		if err = s.scan(func(record *api.Record) error {
+			total++
+			if !keep(record) {
+				return nil
+			}
+			kept++
+			return cleaned.write(record)
+		}); err != nil {
+			cleaned.Close()
+			return err
+		}
+		return cleaned.Close()
+	}); err != nil {
+		return err
+	}
+	if kept == total {
+		return nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// The segment could have been reopened since, but not replaced by
+	// anything else while compactions are held off.
+	i := -1
+	for j, cur := range l.segments {
+		if cur.baseOffset == s.baseOffset {
+			i = j
+		}
+	}
+	// The segment was truncated in the meantime.
+	if i == -1 {
+		return nil
+	}
+	s = l.segments[i]
+
+	segments := make([]*segment, len(l.segments))
+	copy(segments, l.segments)
+	// The first segment is kept even if it's left empty, removing it would
+	// move the start of the log and the offsets it held would count as
+	// truncated instead of moving on to the next surviving record.
+	if kept == 0 && i > 0 {
+		l.segments = append(segments[:i], segments[i+1:]...)
+		// Reads in progress keep the segment open until they're done.
+		l.publish()
+		if err := s.Remove(); err != nil {
+			return err
+		}
+	} else {
+		// The store is renamed first, if the process stops before the indexes
+		// are renamed they won't match it and will be rebuilt on startup. The
+		// files of s stay open for the reads in progress.
+		for _, name := range []string{s.path(".store"), s.path(".index"), s.path(".timeindex")} {
+			if err := os.Rename(path.Join(dir, path.Base(name)), name); err != nil {
+				return err
+			}
+		}
+		compacted, err := newSegment(l.Dir, s.baseOffset, l.sealedConfig())
+		if err != nil {
+			return err
+		}
+		segments[i] = compacted
+		l.segments = segments
+		l.publish()
+		if err := s.Close(); err != nil {
+			return err
+		}
+	}
+
+	l.logger.Info(
+		"compacted segment",
+		zap.Uint64("segment", s.baseOffset),
+		zap.Int("records", total),
+		zap.Int("removed_records", total-kept),
+	)
+	return nil
+}
+
+// compactLoop compacts the log on every configured interval until the log is closed.
+func (l *Log) compactLoop() {
+	defer l.wg.Done()
+
+	ticker := time.NewTicker(l.Config.Compaction.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.done:
+			return
+		case <-ticker.C:
+			if err := l.Compact(); err != nil {
+				l.logger.Error("compaction failed", zap.Error(err))
+			}
+		}
+	}
+}
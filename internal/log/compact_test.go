@@ -0,0 +1,261 @@
+package log
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	api "github.com/AYM1607/proglog/api/v1"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompact(t *testing.T) {
+	dir, err := ioutil.TempDir("", "compact-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := Config{}
+	// This ensures that each segment can hold three records.
+	c.Segment.MaxIndexBytes = entWidth * 3
+	c.Compaction.TombstoneRetention = time.Hour
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+
+	for _, record := range []*api.Record{
+		// First segment.
+		{Key: []byte("k1"), Value: []byte("a")},
+		{Key: []byte("k2"), Value: []byte("a")},
+		{Value: []byte("no key")},
+		// Second segment.
+		{Key: []byte("k1"), Value: []byte("b")},
+		{Key: []byte("k2")},
+		{Key: []byte("k3"), Value: []byte("a")},
+		// Third segment.
+		{Key: []byte("k3"), Value: []byte("b")},
+		{Key: []byte("k4"), Value: []byte("a")},
+		{Key: []byte("k1"), Value: []byte("c")},
+	} {
+		_, err := log.Append(record)
+		require.NoError(t, err)
+	}
+	// The last record in the active segment doesn't count, it's not sealed yet.
+	_, err = log.Append(&api.Record{Key: []byte("k4"), Value: []byte("b")})
+	require.NoError(t, err)
+
+	require.NoError(t, log.Compact())
+
+	for _, want := range []struct {
+		off, got uint64
+	}{
+		// Only the record without a key survives in the first segment.
+		{off: 0, got: 2},
+		{off: 1, got: 2},
+		{off: 2, got: 2},
+		// Tombstones are kept for their retention.
+		{off: 3, got: 4},
+		{off: 4, got: 4},
+		{off: 5, got: 6},
+		{off: 6, got: 6},
+		{off: 7, got: 7},
+		{off: 8, got: 8},
+		{off: 9, got: 9},
+	} {
+		read, err := log.Read(want.off)
+		require.NoError(t, err)
+		require.Equal(t, want.got, read.Offset, "Reading offset %d should return the next surviving record.", want.off)
+	}
+	read, err := log.Read(8)
+	require.NoError(t, err)
+	require.Equal(t, []byte("c"), read.Value, "The latest record of a key survives.")
+
+	off, err := log.LowestOffset()
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), off)
+	off, err = log.HighestOffset()
+	require.NoError(t, err)
+	require.Equal(t, uint64(9), off, "Offsets are preserved.")
+
+	// Once the tombstone is old enough it's removed along with its segment.
+	log.Config.Compaction.TombstoneRetention = 0
+	require.NoError(t, log.Compact())
+	require.Len(t, log.segments, 3)
+	read, err = log.Read(3)
+	require.NoError(t, err)
+	require.Equal(t, uint64(6), read.Offset)
+
+	// Compaction survives restarts and new records can be appended.
+	require.NoError(t, log.Close())
+	log, err = NewLog(dir, c)
+	require.NoError(t, err)
+	for off, want := range map[uint64]uint64{0: 2, 3: 6, 9: 9} {
+		read, err := log.Read(off)
+		require.NoError(t, err)
+		require.Equal(t, want, read.Offset)
+	}
+	off, err = log.Append(&api.Record{Value: []byte("hello world")})
+	require.NoError(t, err)
+	require.Equal(t, uint64(10), off)
+	require.NoError(t, log.Close())
+}
+
+func TestCompactInBackground(t *testing.T) {
+	dir, err := ioutil.TempDir("", "compact-background-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := Config{}
+	c.Segment.MaxIndexBytes = entWidth
+	c.Compaction.Interval = time.Millisecond
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		_, err := log.Append(&api.Record{Key: []byte("key"), Value: []byte("hello world")})
+		require.NoError(t, err)
+	}
+
+	// Every segment holds a single record so the ones with superseded records
+	// are removed, except for the first one that's left empty.
+	require.Eventually(t, func() bool {
+		log.mu.RLock()
+		defer log.mu.RUnlock()
+		return len(log.segments) == 3
+	}, time.Second, time.Millisecond, "Superseded records should be compacted in the background.")
+	read, err := log.Read(0)
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), read.Offset)
+
+	// Closing the log stops the background compaction.
+	require.NoError(t, log.Close())
+}
+
+func TestCompactDoesntBlockAppends(t *testing.T) {
+	dir, err := ioutil.TempDir("", "compact-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := Config{}
+	c.Segment.MaxIndexBytes = entWidth * 2
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+	defer log.Close()
+	appendRecords(t, log, 3)
+
+	// The rewrite of the first segment is stuck on its first record until
+	// it's unblocked, the record is removed.
+	started, unblock := make(chan struct{}), make(chan struct{})
+	keep := func(record *api.Record) bool {
+		if record.Offset == 0 {
+			close(started)
+			<-unblock
+			return false
+		}
+		return true
+	}
+	compacted := make(chan error)
+	go func() {
+		log.compactMu.Lock()
+		defer log.compactMu.Unlock()
+		compacted <- log.compactSegment(log.segments[0], keep)
+	}()
+	<-started
+
+	appended := make(chan struct{})
+	go func() {
+		defer close(appended)
+		// Enough to roll the segments.
+		for i := 0; i < 4; i++ {
+			if _, err := log.Append(&api.Record{Value: []byte("hello world")}); err != nil {
+				t.Error(err)
+			}
+		}
+	}()
+	select {
+	case <-appended:
+	case <-time.After(5 * time.Second):
+		t.Error("Appends shouldn't wait for the compaction.")
+	}
+
+	close(unblock)
+	require.NoError(t, <-compacted)
+	<-appended
+	record, err := log.Read(0)
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), record.Offset)
+}
+
+func TestCompactFirstSegment(t *testing.T) {
+	dir, err := ioutil.TempDir("", "compact-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := Config{}
+	c.Segment.MaxIndexBytes = entWidth * 2
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+	for i := 0; i < 6; i++ {
+		_, err = log.Append(&api.Record{Key: []byte("key"), Value: []byte("hello world")})
+		require.NoError(t, err)
+	}
+	require.NoError(t, log.Compact())
+
+	// Every record of the first segment was compacted, its offsets still
+	// resolve to the next surviving record.
+	for i := 0; i < 2; i++ {
+		off, err := log.LowestOffset()
+		require.NoError(t, err)
+		require.Equal(t, uint64(0), off)
+		read, err := log.Read(0)
+		require.NoError(t, err)
+		require.Equal(t, uint64(5), read.Offset)
+		record, err := log.NewIterator(0).Next()
+		require.NoError(t, err)
+		require.Equal(t, uint64(5), record.Offset)
+
+		// The empty segment survives restarts.
+		require.NoError(t, log.Close())
+		log, err = NewLog(dir, c)
+		require.NoError(t, err)
+	}
+	require.NoError(t, log.Close())
+}
+
+func TestCompactCorruptSegment(t *testing.T) {
+	dir, err := ioutil.TempDir("", "compact-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := Config{}
+	c.Segment.MaxIndexBytes = entWidth * 2
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+	for _, key := range []string{"k1", "k1", "k2", "k2", "k3"} {
+		_, err = log.Append(&api.Record{Key: []byte(key), Value: []byte("hello world")})
+		require.NoError(t, err)
+	}
+	s := log.segments[0]
+	_, pos, err := s.index.Read(0)
+	require.NoError(t, err)
+	require.NoError(t, log.Close())
+
+	// Damage the data of the first record.
+	f, err := os.OpenFile(s.store.Name(), os.O_WRONLY, 0644)
+	require.NoError(t, err)
+	_, err = f.WriteAt([]byte{0}, int64(pos+headerWidth))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	log, err = NewLog(dir, c)
+	require.NoError(t, err)
+	defer log.Close()
+	require.Equal(t, api.ErrCorruptRecord{Segment: 0, Offset: 0}, log.Compact())
+
+	// The segment with the corrupt record is left as it was, the others are compacted.
+	read, err := log.Read(1)
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), read.Offset)
+	read, err = log.Read(2)
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), read.Offset)
+}
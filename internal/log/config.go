@@ -1,9 +1,82 @@
 package log
 
+import "time"
+
 type Config struct {
+	// ReadOnly opens an existing log without writing to it. The directory is
+	// locked in shared mode so other read only logs can open it, but not logs
+	// that write to it.
+	ReadOnly bool
+
 	Segment struct {
 		MaxStoreBytes uint64
 		MaxIndexBytes uint64
 		InitialOffset uint64
+		// Codec compresses the records appended to new and reopened segments.
+		// Records are stored uncompressed if nil.
+		Codec Codec
+		// MaxSegmentAge is how long after its first record the active segment
+		// is rolled, even if it isn't full. There's no age limit if it's zero.
+		MaxSegmentAge time.Duration
+		// MaxOpenSegments is how many sealed segments keep their files open.
+		// The least recently read ones are closed first and opened again when
+		// they're read. Every segment stays open if it's zero.
+		MaxOpenSegments int
+	}
+	Compaction struct {
+		// Interval between background compactions of the sealed segments.
+		// Compaction only runs when Log.Compact is called if it's zero.
+		Interval time.Duration
+		// TombstoneRetention is how long a record with a key and no value is kept
+		// after being appended, so consumers have time to see the deletion.
+		TombstoneRetention time.Duration
+	}
+	Retention struct {
+		// MaxBytes is the max total size of the segments' stores.
+		// There's no size limit if it's zero.
+		MaxBytes uint64
+		// MaxAge is how long records are kept after being appended.
+		// There's no age limit if it's zero.
+		MaxAge time.Duration
+		// Interval between checks of the retention limits, a minute if zero.
+		Interval time.Duration
+	}
+	Tiering struct {
+		// Store is where sealed segments are offloaded to. Segments are only
+		// kept locally if it's nil.
+		Store ObjectStore
+		// MaxLocalAge is how long after their last record sealed segments are
+		// offloaded to the store and removed from the log's directory.
+		MaxLocalAge time.Duration
+		// Interval between checks for segments to offload, a minute if zero.
+		Interval time.Duration
+		// CacheSegments is how many offloaded segments are kept locally after
+		// being fetched to be read, 4 if zero.
+		CacheSegments int
+	}
+	Durability struct {
+		// Mode decides when the appended records are synced to disk.
+		Mode SyncMode
+		// Records is how many appends are synced at once with SyncEveryRecords.
+		Records uint64
+		// Interval between syncs with SyncInterval.
+		Interval time.Duration
 	}
 }
+
+// SyncMode decides when the appended records are synced to disk.
+type SyncMode int
+
+const (
+	// SyncOS hands every record to the operating system before Append returns
+	// and leaves syncing them to disk up to it. Records survive a crash of the
+	// process but not one of the machine.
+	SyncOS SyncMode = iota
+	// SyncAlways syncs every record to disk before Append returns.
+	SyncAlways
+	// SyncEveryRecords syncs to disk once every Durability.Records appends,
+	// the append that completes the count doesn't return until it's done.
+	SyncEveryRecords
+	// SyncInterval syncs to disk in the background on every Durability.Interval.
+	SyncInterval
+)
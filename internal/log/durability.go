@@ -0,0 +1,150 @@
+package log
+
+import (
+	"os"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// defaultSyncInterval is how often SyncInterval syncs if the config doesn't say.
+const defaultSyncInterval = time.Second
+
+// Sync commits every record appended so far to disk.
+func (l *Log) Sync() error {
+	l.commitMu.Lock()
+	defer l.commitMu.Unlock()
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	for _, s := range l.segments {
+		// Sealed segments were synced before their files were closed.
+		if s.closed {
+			continue
+		}
+		if err := s.store.Sync(); err != nil {
+			return err
+		}
+	}
+	l.flushed = l.activeSegment.nextOffset
+	l.synced = l.activeSegment.nextOffset
+	return nil
+}
+
+// needsSync reports whether the n records that were just appended must be
+// synced to disk before returning. It must be called while holding the write lock.
+func (l *Log) needsSync(n uint64) bool {
+	switch l.Config.Durability.Mode {
+	case SyncAlways:
+		return true
+	case SyncEveryRecords:
+		l.unsynced += n
+		if l.unsynced >= l.Config.Durability.Records {
+			l.unsynced = 0
+			return true
+		}
+	}
+	return false
+}
+
+// commit makes sure the record at off is synced to disk if sync is set or at
+// least handed to the operating system otherwise. Concurrent callers are
+// coalesced: the first one commits every record appended so far with a single
+// write and sync, and the ones that were waiting behind it find their records
+// already committed. Only the store is synced, the indexes are rebuilt from it
+// on startup if they don't match.
+func (l *Log) commit(off uint64, sync bool) error {
+	l.commitMu.Lock()
+	defer l.commitMu.Unlock()
+
+	if l.synced > off || (!sync && l.flushed > off) {
+		return nil
+	}
+
+	// Records in sealed segments were committed when the segment was rolled,
+	// so the active segment holds every record that isn't.
+	// It stays open even if it's sealed in the meantime.
+	l.mu.RLock()
+	s, next := l.activeSegment, l.activeSegment.nextOffset
+	s.acquire()
+	l.mu.RUnlock()
+	defer func() {
+		if err := s.release(); err != nil {
+			l.logger.Error("close segment failed", zap.Uint64("segment", s.baseOffset), zap.Error(err))
+		}
+	}()
+
+	if sync {
+		if err := s.store.Sync(); err != nil {
+			return err
+		}
+		l.synced = next
+	} else if err := s.store.Flush(); err != nil {
+		return err
+	}
+	l.flushed = next
+	return nil
+}
+
+// roll seals the active segment and makes a new one starting at off the active one.
+func (l *Log) roll(off uint64) error {
+	durable := l.Config.Durability.Mode != SyncOS
+	// Records must be committed before their segment is sealed. Only the last
+	// index entry of sealed segments is checked on startup, so their indexes
+	// must be on disk too.
+	if durable {
+		if err := l.activeSegment.Sync(); err != nil {
+			return err
+		}
+	} else if err := l.activeSegment.store.Flush(); err != nil {
+		return err
+	}
+	if err := l.newSegment(off); err != nil {
+		return err
+	}
+	// Only the active segment keeps a write handle.
+	if err := l.seal(len(l.segments) - 2); err != nil {
+		return err
+	}
+	// Producers are only replayed from the active segment on startup.
+	if err := l.snapshotProducers(); err != nil {
+		return err
+	}
+	// The files of the new segment aren't durable until their directory entries are.
+	if durable {
+		return syncDir(l.Dir)
+	}
+	return nil
+}
+
+// syncLoop syncs the log on every configured interval until the log is closed.
+func (l *Log) syncLoop() {
+	defer l.wg.Done()
+
+	interval := l.Config.Durability.Interval
+	if interval == 0 {
+		interval = defaultSyncInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.done:
+			return
+		case <-ticker.C:
+			if err := l.Sync(); err != nil {
+				l.logger.Error("sync failed", zap.Error(err))
+			}
+		}
+	}
+}
+
+// syncDir commits the entries of the directory to disk.
+func syncDir(dir string) error {
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return f.Sync()
+}
@@ -0,0 +1,165 @@
+package log
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	api "github.com/AYM1607/proglog/api/v1"
+	"github.com/stretchr/testify/require"
+	"go.opencensus.io/stats/view"
+)
+
+func TestDurability(t *testing.T) {
+	for scenario, fn := range map[string]func(
+		t *testing.T, c Config, dir string,
+	){
+		"os managed":         testSyncOS,
+		"sync always":        testSyncAlways,
+		"sync every records": testSyncEveryRecords,
+		"sync interval":      testSyncInterval,
+		"explicit sync":      testExplicitSync,
+		"group commit":       testGroupCommit,
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "durability-test")
+			require.NoError(t, err)
+			defer os.RemoveAll(dir)
+
+			c := Config{}
+			c.Segment.MaxIndexBytes = 1024
+			fn(t, c, dir)
+		})
+	}
+}
+
+func testSyncOS(t *testing.T, c Config, dir string) {
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+	defer log.Close()
+
+	appendRecords(t, log, 1)
+	s := log.activeSegment.store
+	require.Equal(t, 0, s.buf.Buffered(), "Records should be handed to the OS.")
+	_, size, err := openFile(s.Name())
+	require.NoError(t, err)
+	require.Equal(t, s.size, uint64(size))
+	require.Equal(t, uint64(0), s.synced, "Records should not be synced.")
+}
+
+func testSyncAlways(t *testing.T, c Config, dir string) {
+	c.Durability.Mode = SyncAlways
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+	defer log.Close()
+
+	for i := 0; i < 3; i++ {
+		appendRecords(t, log, 1)
+		s := log.activeSegment.store
+		require.Equal(t, s.size, s.synced, "Every record should be synced.")
+	}
+}
+
+func testSyncEveryRecords(t *testing.T, c Config, dir string) {
+	c.Durability.Mode = SyncEveryRecords
+	c.Durability.Records = 3
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+	defer log.Close()
+
+	s := log.activeSegment.store
+	appendRecords(t, log, 2)
+	require.Equal(t, uint64(0), s.synced, "Records should be synced in groups.")
+	appendRecords(t, log, 1)
+	require.Equal(t, s.size, s.synced, "The last record of a group should be synced with the others.")
+}
+
+func testSyncInterval(t *testing.T, c Config, dir string) {
+	c.Durability.Mode = SyncInterval
+	c.Durability.Interval = time.Millisecond
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+
+	appendRecords(t, log, 1)
+	require.Eventually(t, func() bool {
+		log.mu.Lock()
+		defer log.mu.Unlock()
+		s := log.activeSegment.store
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return s.synced == s.size
+	}, time.Second, time.Millisecond, "Records should be synced in the background.")
+
+	// Closing the log stops the background syncs.
+	require.NoError(t, log.Close())
+}
+
+func testExplicitSync(t *testing.T, c Config, dir string) {
+	// Make every segment hold a single record.
+	c.Segment.MaxIndexBytes = entWidth
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+	defer log.Close()
+
+	appendRecords(t, log, 2)
+	_, err = log.activeSegment.Append(&api.Record{Value: []byte("hello world")})
+	require.NoError(t, err)
+
+	require.NoError(t, log.Sync())
+	for _, s := range log.segments {
+		require.Equal(t, s.store.size, s.store.synced, "Every segment should be synced.")
+	}
+}
+
+func testGroupCommit(t *testing.T, c Config, dir string) {
+	c.Durability.Mode = SyncAlways
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+	defer log.Close()
+
+	// Hold off commits so every append is waiting for the same one.
+	log.commitMu.Lock()
+	syncs := storeSyncCount(t)
+	type appended struct {
+		off uint64
+		err error
+	}
+	results := make(chan appended)
+	for i := 0; i < 10; i++ {
+		go func() {
+			off, err := log.Append(&api.Record{Value: []byte("hello world")})
+			results <- appended{off: off, err: err}
+		}()
+	}
+	require.Eventually(t, func() bool {
+		log.mu.RLock()
+		defer log.mu.RUnlock()
+		return log.activeSegment.nextOffset == 10
+	}, time.Second, time.Millisecond, "Appends should not wait for each other's commits.")
+	s := log.activeSegment.store
+	require.Equal(t, uint64(0), s.synced)
+
+	log.commitMu.Unlock()
+	seen := make(map[uint64]bool)
+	for i := 0; i < 10; i++ {
+		res := <-results
+		require.NoError(t, res.err)
+		require.False(t, seen[res.off], "Every append should get its own offset.")
+		seen[res.off] = true
+	}
+	require.Equal(t, s.size, s.synced, "Every record should be synced.")
+	require.Equal(t, log.synced, uint64(10))
+	require.Equal(t, syncs+1, storeSyncCount(t), "The appends should be synced together.")
+}
+
+// storeSyncCount returns the number of times a store was synced to disk.
+func storeSyncCount(t *testing.T) int64 {
+	t.Helper()
+	rows, err := view.RetrieveData(storeSyncs.Name())
+	require.NoError(t, err)
+	if len(rows) == 0 {
+		return 0
+	}
+	return rows[0].Data.(*view.CountData).Value
+}
@@ -1,38 +1,113 @@
 package log
 
 import (
+	"bytes"
+	"fmt"
 	"io"
+	"io/ioutil"
+	"math"
 	"os"
+	"strconv"
+	"sync/atomic"
 
 	"github.com/tysontate/gommap"
 )
 
 var (
-	offWidth uint64 = 4
+	offWidth uint64 = 8
 	posWidth uint64 = 8
 	entWidth        = offWidth + posWidth
+
+	// indexMagic starts the header of every index, followed by its version.
+	indexMagic = []byte("PLIX")
+	// legacyOffWidth is the width of the relative offsets of indexes written
+	// before the header was added. Their entries are migrated when opened.
+	legacyOffWidth uint64 = 4
+	legacyEntWidth        = legacyOffWidth + posWidth
+
+	// maxIndexBytes is the largest MaxIndexBytes that can be memory mapped on this platform.
+	maxIndexBytes = uint64(math.MaxInt64>>(64-strconv.IntSize)) - indexHeaderWidth
+)
+
+const (
+	// indexVersion is the version of the index format, indexes without a header are version 1.
+	indexVersion = 2
+	// indexHeaderWidth is the number of bytes before the first entry of an index.
+	indexHeaderWidth = 8
+	// migrateExt is added to the name of an index while it's migrated.
+	migrateExt = ".migrate"
 )
 
 type index struct {
 	file *os.File
+	// mmap holds the header followed by the entries.
 	mmap gommap.MMap
+	// size is the number of bytes taken by the entries, the header isn't counted.
+	// It's only set once the entries are written so they can be read while the
+	// index is written to.
 	size uint64
+	// readOnly indexes are mapped as they are and never written to.
+	readOnly bool
+	// migrated is set on read only indexes in the old format, their entries
+	// are migrated to memory instead of mapped.
+	migrated bool
 }
 
 func newIndex(f *os.File, c Config) (*index, error) {
 	idx := &index{
-		file: f,
+		file:     f,
+		readOnly: c.ReadOnly,
 	}
-	fi, err := os.Stat(f.Name())
+
+	header, err := readIndexHeader(f)
 	if err != nil {
 		return nil, err
 	}
+	if header == nil && !idx.readOnly {
+		if idx.file, err = migrateIndex(f); err != nil {
+			return nil, err
+		}
+	}
 
-	// Get and store the file size.
-	idx.size = uint64(fi.Size())
+	fi, err := os.Stat(idx.file.Name())
+	if err != nil {
+		return nil, err
+	}
+
+	if idx.readOnly {
+		// Empty files can't be mapped, there's nothing to read from them anyway.
+		if fi.Size() == 0 {
+			return idx, nil
+		}
+		// Indexes in the old format are migrated in memory.
+		if header == nil {
+			b, err := ioutil.ReadAll(idx.file)
+			if err != nil {
+				return nil, err
+			}
+			idx.mmap = migrateEntries(b)
+			idx.migrated = true
+			idx.size = uint64(len(idx.mmap)) - indexHeaderWidth
+			return idx, nil
+		}
+		if idx.mmap, err = gommap.Map(
+			idx.file.Fd(),
+			gommap.PROT_READ,
+			gommap.MAP_SHARED,
+		); err != nil {
+			return nil, err
+		}
+		idx.size = uint64(fi.Size()) - indexHeaderWidth
+		return idx, nil
+	}
+
+	// Get and store the size of the entries.
+	if fi.Size() > 0 {
+		idx.size = uint64(fi.Size()) - indexHeaderWidth
+	}
 	// Grow the file to its max size so it can be memory mapped as a whole.
 	if err = os.Truncate(
-		f.Name(), int64(c.Segment.MaxIndexBytes),
+		idx.file.Name(), int64(indexHeaderWidth+c.Segment.MaxIndexBytes),
 	); err != nil {
 		return nil, err
 	}
@@ -44,55 +119,207 @@ func newIndex(f *os.File, c Config) (*index, error) {
 	); err != nil {
 		return nil, err
 	}
+	// New indexes get their header.
+	if fi.Size() == 0 {
+		copy(idx.mmap, newIndexHeader())
+	}
 
 	return idx, nil
 }
 
-func (i *index) Read(in int64) (out uint32, pos uint64, err error) {
-	if i.size == 0 {
+// checkMaxIndexBytes makes sure an index of n bytes holds at least one entry
+// and can be memory mapped as a whole.
+func checkMaxIndexBytes(n uint64) error {
+	if n < entWidth {
+		return fmt.Errorf("max index bytes %d is less than an index entry, %d bytes", n, entWidth)
+	}
+	if n > maxIndexBytes {
+		return fmt.Errorf("max index bytes %d is more than the %d bytes an index can map", n, maxIndexBytes)
+	}
+	return nil
+}
+
+func newIndexHeader() []byte {
+	header := make([]byte, indexHeaderWidth)
+	copy(header, indexMagic)
+	enc.PutUint32(header[len(indexMagic):], indexVersion)
+	return header
+}
+
+// readIndexHeader returns the header of the index file, nil if it doesn't
+// have one because it's empty or was written in the old format.
+func readIndexHeader(f *os.File) ([]byte, error) {
+	header := make([]byte, indexHeaderWidth)
+	n, err := f.ReadAt(header, 0)
+	if err == io.EOF && n == 0 {
+		return nil, nil
+	}
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	// The first entry of old indexes has a relative offset of 0, so they
+	// never start with the magic.
+	if n < int(indexHeaderWidth) || !bytes.Equal(header[:len(indexMagic)], indexMagic) {
+		return nil, nil
+	}
+	if v := enc.Uint32(header[len(indexMagic):]); v != indexVersion {
+		return nil, fmt.Errorf("index %s has unsupported version %d", f.Name(), v)
+	}
+	return header, nil
+}
+
+// migrateIndex rewrites an index in the old format, or an empty one, in the
+// current format and returns the file of the new one. The new index replaces
+// the old one at once so it's either migrated or not if the process stops.
+func migrateIndex(f *os.File) (*os.File, error) {
+	b, err := ioutil.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) == 0 {
+		return f, nil
+	}
+
+	tmp, err := os.OpenFile(f.Name()+migrateExt, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = tmp.Write(migrateEntries(b)); err != nil {
+		tmp.Close()
+		return nil, err
+	}
+	if err = tmp.Sync(); err != nil {
+		tmp.Close()
+		return nil, err
+	}
+	if err = os.Rename(tmp.Name(), f.Name()); err != nil {
+		tmp.Close()
+		return nil, err
+	}
+	if err = f.Close(); err != nil {
+		tmp.Close()
+		return nil, err
+	}
+	// The new file keeps the name of the old one.
+	migrated, err := os.OpenFile(f.Name(), os.O_RDWR, 0644)
+	tmp.Close()
+	return migrated, err
+}
+
+// migrateEntries returns the header followed by the entries in the old format b.
+// Incomplete entries at the end are dropped.
+func migrateEntries(b []byte) []byte {
+	n := uint64(len(b)) / legacyEntWidth
+	out := make([]byte, indexHeaderWidth+n*entWidth)
+	copy(out, newIndexHeader())
+	for i := uint64(0); i < n; i++ {
+		old := b[i*legacyEntWidth : (i+1)*legacyEntWidth]
+		ent := out[indexHeaderWidth+i*entWidth : indexHeaderWidth+(i+1)*entWidth]
+		enc.PutUint64(ent[:offWidth], uint64(enc.Uint32(old[:legacyOffWidth])))
+		copy(ent[offWidth:], old[legacyOffWidth:])
+	}
+	return out
+}
+
+func (i *index) Read(in int64) (out uint64, pos uint64, err error) {
+	size := atomic.LoadUint64(&i.size)
+	if size == 0 {
 		return 0, 0, io.EOF
 	}
 	if in == -1 {
 		// The last entity was requested
-		out = uint32((i.size / entWidth) - 1)
+		out = (size / entWidth) - 1
 	} else {
-		out = uint32(in)
+		out = uint64(in)
 	}
 
-	pos = uint64(out) * entWidth
+	pos = indexHeaderWidth + out*entWidth
 
 	// Check that the index actually contains the record with the given offset.
-	if i.size < pos+entWidth {
+	if indexHeaderWidth+size < pos+entWidth {
 		return 0, 0, io.EOF
 	}
 
-	out = enc.Uint32(i.mmap[pos : pos+offWidth])
+	out = enc.Uint64(i.mmap[pos : pos+offWidth])
 	pos = enc.Uint64(i.mmap[pos+offWidth : pos+entWidth])
 	return out, pos, nil
 }
 
-func (i *index) Write(off uint32, pos uint64) error {
-	if uint64(len(i.mmap)) < i.size+entWidth {
+func (i *index) Write(off uint64, pos uint64) error {
+	if i.isMaxed() {
+		return io.EOF
+	}
+	at := indexHeaderWidth + i.size
+	enc.PutUint64(i.mmap[at:at+offWidth], off)
+	enc.PutUint64(i.mmap[at+offWidth:at+entWidth], pos)
+	atomic.StoreUint64(&i.size, i.size+entWidth)
+	return nil
+}
+
+// entries returns the number of entries in the index.
+func (i *index) entries() uint64 {
+	return atomic.LoadUint64(&i.size) / entWidth
+}
+
+// isMaxed reports whether there's no room for another entry.
+func (i *index) isMaxed() bool {
+	return uint64(len(i.mmap)) < indexHeaderWidth+i.size+entWidth
+}
+
+// Truncate discards every entry of the index from the entry number n onwards.
+func (i *index) Truncate(n uint64) error {
+	if n*entWidth > i.size {
 		return io.EOF
 	}
-	enc.PutUint32(i.mmap[i.size:i.size+offWidth], off)
-	enc.PutUint64(i.mmap[i.size+offWidth:i.size+entWidth], pos)
-	i.size += entWidth
+	atomic.StoreUint64(&i.size, n*entWidth)
 	return nil
 }
 
+// shrink truncates the file to the entries written so far so it can be opened
+// read only. Nothing can be written to the index afterwards.
+func (i *index) shrink() error {
+	return i.file.Truncate(int64(indexHeaderWidth + i.size))
+}
+
+// Bytes returns the contents of the index as they're written to its file.
+func (i *index) Bytes() []byte {
+	if i.mmap == nil {
+		return nil
+	}
+	return i.mmap[:indexHeaderWidth+i.size]
+}
+
+// Sync commits the entries written so far to disk.
+func (i *index) Sync() error {
+	return i.mmap.Sync(gommap.MS_SYNC)
+}
+
 func (i *index) Name() string {
 	return i.file.Name()
 }
 
 func (i *index) Close() error {
+	if i.readOnly {
+		if i.mmap != nil && !i.migrated {
+			if err := i.mmap.UnsafeUnmap(); err != nil {
+				return err
+			}
+		}
+		i.mmap = nil
+		return i.file.Close()
+	}
 	if err := i.mmap.Sync(gommap.MS_SYNC); err != nil {
 		return err
 	}
 	if err := i.file.Sync(); err != nil {
 		return err
 	}
-	if err := i.file.Truncate(int64(i.size)); err != nil {
+	// The file is truncated below the mapping's size, it can't be mapped anymore.
+	if err := i.mmap.UnsafeUnmap(); err != nil {
+		return err
+	}
+	i.mmap = nil
+	if err := i.file.Truncate(int64(indexHeaderWidth + i.size)); err != nil {
 		return err
 	}
 
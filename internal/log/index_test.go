@@ -27,7 +27,7 @@ func TestIndex(t *testing.T) {
 	require.Equal(t, f.Name(), idx.Name(), "Name should return the same name as the underlying file.")
 
 	entries := []struct {
-		Off uint32
+		Off uint64
 		Pos uint64
 	}{
 		{Off: 0, Pos: 0},
@@ -52,6 +52,66 @@ func TestIndex(t *testing.T) {
 	require.NoError(t, err, "No error when creating index from an existing file.")
 	off, pos, err := idx.Read(-1)
 	require.NoError(t, err, "No error when reading the last record of a non empty index.")
-	require.Equal(t, uint32(1), off)
+	require.Equal(t, uint64(1), off)
 	require.Equal(t, entries[1].Pos, pos)
 }
+
+func TestIndexMigration(t *testing.T) {
+	f, err := ioutil.TempFile("", "index_migration_test")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+
+	// Indexes written before the header have 4 byte relative offsets.
+	legacy := make([]byte, 2*legacyEntWidth)
+	for i := uint64(0); i < 2; i++ {
+		ent := legacy[i*legacyEntWidth:]
+		enc.PutUint32(ent, uint32(i))
+		enc.PutUint64(ent[legacyOffWidth:], i*10)
+	}
+	_, err = f.Write(legacy)
+	require.NoError(t, err)
+	_, err = f.Seek(0, io.SeekStart)
+	require.NoError(t, err)
+
+	c := Config{}
+	c.Segment.MaxIndexBytes = 1024
+	ro := c
+	ro.ReadOnly = true
+	idx, err := newIndex(f, ro)
+	require.NoError(t, err)
+	off, pos, err := idx.Read(-1)
+	require.NoError(t, err, "Read only indexes in the old format should be readable.")
+	require.Equal(t, uint64(1), off)
+	require.Equal(t, uint64(10), pos)
+	require.NoError(t, idx.Close())
+	b, err := ioutil.ReadFile(f.Name())
+	require.NoError(t, err)
+	require.Equal(t, legacy, b, "Read only indexes shouldn't be migrated on disk.")
+
+	f, err = os.OpenFile(f.Name(), os.O_RDWR, 0600)
+	require.NoError(t, err)
+	idx, err = newIndex(f, c)
+	require.NoError(t, err)
+	require.NoError(t, idx.Write(2, 20))
+	for n := int64(0); n < 3; n++ {
+		off, pos, err := idx.Read(n)
+		require.NoError(t, err)
+		require.Equal(t, uint64(n), off)
+		require.Equal(t, uint64(n*10), pos)
+	}
+	require.NoError(t, idx.Close())
+
+	b, err = ioutil.ReadFile(f.Name())
+	require.NoError(t, err)
+	require.Equal(t, newIndexHeader(), b[:indexHeaderWidth])
+	require.Equal(t, int(indexHeaderWidth+3*entWidth), len(b))
+
+	// Indexes from newer versions aren't read.
+	enc.PutUint32(b[len(indexMagic):], indexVersion+1)
+	require.NoError(t, ioutil.WriteFile(f.Name(), b, 0600))
+	f, err = os.OpenFile(f.Name(), os.O_RDWR, 0600)
+	require.NoError(t, err)
+	defer f.Close()
+	_, err = newIndex(f, c)
+	require.Error(t, err)
+}
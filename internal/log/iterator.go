@@ -0,0 +1,115 @@
+package log
+
+import (
+	"errors"
+	"io"
+	"sort"
+
+	api "github.com/AYM1607/proglog/api/v1"
+)
+
+// ErrTruncated is returned by an iterator whose next offset was removed from the log.
+var ErrTruncated = errors.New("iterator offset was truncated from the log")
+
+// Iterator reads the records of a log in order, across segments.
+type Iterator struct {
+	log *Log
+	// next is the offset of the next record to read and i the position, in
+	// the log's segments, of the segment that was last read from.
+	next uint64
+	i    int
+}
+
+// NewIterator returns an iterator positioned at the first record at or after from.
+func (l *Log) NewIterator(from uint64) *Iterator {
+	return &Iterator{log: l, next: from}
+}
+
+// Next returns the next record of the log. io.EOF is returned once every
+// record appended so far was read, Next can be called again after new
+// appends to continue. ErrTruncated is returned if the offset the iterator
+// was positioned at was removed from the log.
+func (it *Iterator) Next() (*api.Record, error) {
+	l := it.log
+	var record *api.Record
+	err := l.retry(l.loadView(), func(v *segmentView) error {
+		if it.next < v.segments[0].baseOffset {
+			if it.next < v.lowestOffset() {
+				return ErrTruncated
+			}
+			var err error
+			record, err = it.nextRemote()
+			return err
+		}
+
+		segments := v.segments
+		it.seek(segments)
+		for {
+			s, err := l.open(segments[it.i])
+			if err != nil {
+				return err
+			}
+			record, err = s.Read(it.next)
+			l.release(s)
+			if err == io.EOF {
+				if it.i == len(segments)-1 {
+					return io.EOF
+				}
+				// The rest of the segment was compacted, move on to the next one.
+				it.i++
+				if base := segments[it.i].baseOffset; base > it.next {
+					it.next = base
+				}
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			it.next = record.Offset + 1
+			return nil
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+// nextRemote reads the next record when it's in the segments offloaded to the
+// object store, which the log reads without holding the lock.
+func (it *Iterator) nextRemote() (*api.Record, error) {
+	record, err := it.log.Read(it.next)
+	if e, ok := err.(api.ErrOffsetOutOfRange); ok {
+		if e.Truncated() {
+			return nil, ErrTruncated
+		}
+		return nil, io.EOF
+	}
+	if err != nil {
+		return nil, err
+	}
+	it.next = record.Offset + 1
+	return record, nil
+}
+
+// Offset returns the offset the iterator will read from next.
+func (it *Iterator) Offset() uint64 {
+	return it.next
+}
+
+// seek points i at the segment that holds the next offset. Segments can be
+// added, removed or replaced between calls, so the last one is only reused if
+// it still covers the offset.
+func (it *Iterator) seek(segments []*segment) {
+	covers := func(i int) bool {
+		return i < len(segments) &&
+			segments[i].baseOffset <= it.next &&
+			(i == len(segments)-1 || it.next < segments[i+1].baseOffset)
+	}
+	if covers(it.i) {
+		return
+	}
+	it.i = sort.Search(len(segments), func(i int) bool {
+		return segments[i].baseOffset > it.next
+	}) - 1
+}
@@ -0,0 +1,56 @@
+package log
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestIterator(t *testing.T) {
+	dir, err := ioutil.TempDir("", "iterator-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := Config{}
+	// This ensures that each segment can only hold one record.
+	c.Segment.MaxIndexBytes = entWidth
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+	defer log.Close()
+
+	appendRecords(t, log, 3)
+
+	it := log.NewIterator(1)
+	for want := uint64(1); want < 3; want++ {
+		record, err := it.Next()
+		require.NoError(t, err)
+		require.Equal(t, want, record.Offset, "Records should be read in order across segments.")
+	}
+	_, err = it.Next()
+	require.Equal(t, io.EOF, err, "The iterator should report when it's caught up.")
+
+	// New appends are picked up where the iterator stopped.
+	appendRecords(t, log, 2)
+	for want := uint64(3); want < 5; want++ {
+		record, err := it.Next()
+		require.NoError(t, err)
+		require.Equal(t, want, record.Offset)
+	}
+	_, err = it.Next()
+	require.Equal(t, io.EOF, err)
+	require.Equal(t, uint64(5), it.Offset())
+
+	// Iterators positioned on removed offsets fail.
+	it = log.NewIterator(0)
+	require.NoError(t, log.Truncate(1))
+	_, err = it.Next()
+	require.Equal(t, ErrTruncated, err)
+
+	it = log.NewIterator(2)
+	record, err := it.Next()
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), record.Offset)
+}
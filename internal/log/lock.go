@@ -0,0 +1,53 @@
+package log
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"syscall"
+)
+
+// lockFile is the file, inside the log's directory, that is locked while a log has it open.
+const lockFile = "LOCK"
+
+var (
+	// ErrLocked is returned when opening a log whose directory is locked by another log.
+	ErrLocked = errors.New("log directory is locked by another log")
+	// ErrReadOnly is returned by the operations that write to a read only log.
+	ErrReadOnly = errors.New("log is read only")
+)
+
+// lock takes the lock of the log's directory, a shared one if the log is read
+// only and an exclusive one otherwise. The lock is held by the open file so
+// it's released if the process stops.
+func (l *Log) lock() error {
+	flag, how := os.O_RDWR|os.O_CREATE, syscall.LOCK_EX
+	if l.Config.ReadOnly {
+		flag, how = os.O_RDONLY|os.O_CREATE, syscall.LOCK_SH
+	}
+	f, err := os.OpenFile(path.Join(l.Dir, lockFile), flag, 0644)
+	if err != nil {
+		return err
+	}
+	if err = syscall.Flock(int(f.Fd()), how|syscall.LOCK_NB); err != nil {
+		f.Close()
+		if err == syscall.EWOULDBLOCK {
+			return fmt.Errorf("%w: %s", ErrLocked, l.Dir)
+		}
+		return err
+	}
+	l.lockFile = f
+	return nil
+}
+
+// unlock releases the lock of the log's directory.
+func (l *Log) unlock() error {
+	if l.lockFile == nil {
+		return nil
+	}
+	// Closing the file releases the lock.
+	err := l.lockFile.Close()
+	l.lockFile = nil
+	return err
+}
@@ -0,0 +1,63 @@
+package log
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	api "github.com/AYM1607/proglog/api/v1"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLock(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lock-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	readOnly := Config{ReadOnly: true}
+	_, err = NewLog(dir, readOnly)
+	require.Error(t, err, "Read only logs can't be created.")
+
+	log, err := NewLog(dir, Config{})
+	require.NoError(t, err)
+	appendRecords(t, log, 3)
+
+	_, err = NewLog(dir, Config{})
+	require.True(t, errors.Is(err, ErrLocked), "A log can only be opened once.")
+	_, err = NewLog(dir, readOnly)
+	require.True(t, errors.Is(err, ErrLocked), "A log can't be read while it's written to.")
+	require.NoError(t, log.Close())
+
+	// Any number of read only logs can be opened at once.
+	first, err := NewLog(dir, readOnly)
+	require.NoError(t, err)
+	second, err := NewLog(dir, readOnly)
+	require.NoError(t, err)
+	_, err = NewLog(dir, Config{})
+	require.True(t, errors.Is(err, ErrLocked))
+
+	read, err := first.Read(2)
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), read.Offset)
+	_, err = first.Append(&api.Record{Value: []byte("hello world")})
+	require.Equal(t, ErrReadOnly, err)
+	require.Equal(t, ErrReadOnly, first.Truncate(1))
+	require.Equal(t, ErrReadOnly, first.Remove())
+	require.NoError(t, first.Close())
+	require.NoError(t, second.Close())
+
+	// Closing the read only logs didn't change the files.
+	log, err = NewLog(dir, Config{})
+	require.NoError(t, err)
+	off, err := log.Append(&api.Record{Value: []byte("hello world")})
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), off)
+
+	// Removing the log releases its lock.
+	require.NoError(t, log.Remove())
+	require.NoError(t, os.MkdirAll(dir, 0755))
+	log, err = NewLog(dir, Config{})
+	require.NoError(t, err)
+	require.NoError(t, log.Close())
+}
@@ -1,6 +1,7 @@
 package log
 
 import (
+	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
@@ -9,10 +10,17 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	api "github.com/AYM1607/proglog/api/v1"
+	"go.opencensus.io/stats/view"
+	"go.uber.org/zap"
 )
 
+// rebuildDir is the directory, inside the log's, where sealed segments are rebuilt.
+const rebuildDir = ".rebuild"
+
 type Log struct {
 	mu sync.RWMutex
 
@@ -21,6 +29,42 @@ type Log struct {
 
 	activeSegment *segment
 	segments      []*segment
+	// remote are the segments offloaded to the object store, they come before
+	// the local ones and are read through the cache.
+	remote []remoteSegment
+	cache  *segmentCache
+	// view holds the *segmentView that reads go through, it's published
+	// whenever the fields above change.
+	view atomic.Value
+	// closed is set once the segments are closed so they aren't opened again.
+	closed bool
+	// unsynced counts the appends since the last sync with SyncEveryRecords.
+	unsynced uint64
+	// producers holds the last sequences appended by every idempotent producer.
+	producers *producers
+
+	// commitMu is held by the append that commits the records of every
+	// concurrent one. Records before flushed were handed to the OS and records
+	// before synced were synced to disk.
+	commitMu sync.Mutex
+	flushed  uint64
+	synced   uint64
+
+	// appended is closed, and reset, when new records are committed to wake
+	// up the callers of Wait.
+	appendedMu sync.Mutex
+	appended   chan struct{}
+
+	// compactMu makes sure a single compaction runs at a time.
+	compactMu sync.Mutex
+	// done is closed to stop the background goroutines, which wg tracks.
+	done chan struct{}
+	wg   sync.WaitGroup
+
+	// lockFile holds the lock of the directory while the log is open.
+	lockFile *os.File
+
+	logger *zap.Logger
 }
 
 func NewLog(dir string, c Config) (*Log, error) {
@@ -31,127 +75,698 @@ func NewLog(dir string, c Config) (*Log, error) {
 	if c.Segment.MaxStoreBytes == 0 {
 		c.Segment.MaxStoreBytes = 1024
 	}
+	if err := checkMaxIndexBytes(c.Segment.MaxIndexBytes); err != nil {
+		return nil, err
+	}
 
 	l := &Log{
 		Dir:    dir,
 		Config: c,
+		logger: zap.L().Named("log"),
 	}
 
-	return l, l.setup()
+	if err := view.Register(Views...); err != nil {
+		return nil, err
+	}
+	if err := l.lock(); err != nil {
+		return nil, err
+	}
+	if err := l.setup(); err != nil {
+		l.unlock()
+		return nil, err
+	}
+	l.start()
+	return l, nil
 }
 
-func (l *Log) setup() error {
-	files, err := ioutil.ReadDir(l.Dir)
+// segmentExts are the extensions of the files that make up a segment.
+var segmentExts = []string{".store", ".index", ".timeindex"}
+
+func (l *Log) setup() (err error) {
+	baseOffsets, err := l.segmentOffsets()
 	if err != nil {
 		return err
 	}
-
-	// Get all the base offsets for the existing segments. This is posible because
-	// the .index and .store files have their base offset as their name.
-	var baseOffsets []uint64
-	for _, file := range files {
-		offStr := strings.TrimSuffix(
-			file.Name(),
-			path.Ext(file.Name()),
-		)
-		off, _ := strconv.ParseUint(offStr, 10, 0)
-		baseOffsets = append(baseOffsets, off)
+	remote, err := l.remoteSegments()
+	if err != nil {
+		return err
 	}
 
-	// Sort ascending.
-	sort.Slice(baseOffsets, func(i, j int) bool {
-		return baseOffsets[i] < baseOffsets[j]
-	})
-
-	// Create a segment for each of the base offsets.
-	for i := 0; i < len(baseOffsets); i++ {
-		if err = l.newSegment(baseOffsets[i]); err != nil {
-			return nil
+	// Don't leave the segments that were opened behind if the log can't be.
+	defer func() {
+		if err == nil {
+			return
+		}
+		for _, s := range l.segments {
+			s.Close()
+		}
+		l.segments = nil
+		l.activeSegment = nil
+	}()
+
+	// Open the segments one at a time, closing the sealed ones over the max
+	// number of open segments along the way.
+	for i, off := range baseOffsets {
+		s, err := l.openSegment(off, i == len(baseOffsets)-1)
+		if err != nil {
+			return err
+		}
+		l.segments = append(l.segments, s)
+		l.activeSegment = s
+		for _, s := range l.evict() {
+			if err = s.Close(); err != nil {
+				return err
+			}
 		}
-		// baseOffsets contains 2 entries for each base offset, one for the index and one for the store.
-		// dedup.
-		i++
 	}
 
 	// nil is the zero value for a slice, check if the log is new (no segments)
 	if l.segments == nil {
-		if err = l.newSegment(
-			l.Config.Segment.InitialOffset,
-		); err != nil {
+		if l.Config.ReadOnly {
+			return fmt.Errorf("no log to open read only in %s", l.Dir)
+		}
+		// The log carries on after the segments that were offloaded.
+		off := l.Config.Segment.InitialOffset
+		if n := len(remote); n > 0 && remote[n-1].NextOffset > off {
+			off = remote[n-1].NextOffset
+		}
+		if err = l.newSegment(off); err != nil {
 			return err
 		}
 	}
 
+	if err = l.setupRemote(remote); err != nil {
+		return err
+	}
+	l.closed = false
+	l.publish()
+	if err = l.setupProducers(); err != nil {
+		return err
+	}
+	// Records are only appended in the current format, an active segment
+	// written before stores had a header is sealed as it is.
+	if !l.Config.ReadOnly && l.activeSegment.store.legacy {
+		if err = l.roll(l.activeSegment.nextOffset); err != nil {
+			return err
+		}
+	}
+
+	// Whatever is already in the files counts as committed.
+	l.flushed = l.activeSegment.nextOffset
+	l.synced = l.activeSegment.nextOffset
 	return nil
 }
 
-func (l *Log) Append(record *api.Record) (uint64, error) {
+// openSegment opens the segment with the given base offset and makes sure its
+// index matches its store. Only the active segment is written to, the others
+// are opened read only and only opened to write to if their indexes have to
+// be rebuilt.
+func (l *Log) openSegment(off uint64, active bool) (*segment, error) {
+	if !active && !l.Config.ReadOnly {
+		// Indexes that can't be opened read only, like missing ones, are
+		// rebuilt below.
+		if s, err := newSegment(l.Dir, off, l.sealedConfig()); err == nil {
+			ok, err := s.indexMatchesStore(false)
+			if err == nil && ok {
+				// Count as used so the latest segments are kept open.
+				s.touch()
+				return s, nil
+			}
+			if cerr := s.Close(); err == nil {
+				err = cerr
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	s, err := newSegment(l.Dir, off, l.Config)
+	if err != nil {
+		return nil, fmt.Errorf("open segment %d: %w", off, err)
+	}
+	if err = l.checkSegment(s, active); err != nil {
+		s.Close()
+		return nil, err
+	}
+	if active || l.Config.ReadOnly {
+		return s, nil
+	}
+	sealed, err := l.reopenSealed(s)
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+	return sealed, s.Close()
+}
+
+// checkSegment makes sure the index of s matches its store and rebuilds it if it
+// doesn't. Only the active segment could have been written to when the process
+// stopped so it's the only one that gets every record checked, it must end in a
+// complete and valid record and its torn tail is dropped. The stores of the
+// other segments are left as they are.
+func (l *Log) checkSegment(s *segment, active bool) error {
+	ok, err := s.indexMatchesStore(active)
+	if err != nil || ok {
+		return err
+	}
+	if l.Config.ReadOnly {
+		return fmt.Errorf("index of segment %d doesn't match its store, open the log to write to rebuild it", s.baseOffset)
+	}
+	if !active {
+		corrupt, err := s.RebuildSealedIndex()
+		if err != nil {
+			return err
+		}
+		l.logger.Warn(
+			"rebuilt sealed segment index from its store",
+			zap.Uint64("segment", s.baseOffset),
+			zap.Uint64("corrupt_records", corrupt),
+		)
+		return nil
+	}
+	records, bytes, err := s.RebuildIndex()
+	if err != nil {
+		return err
+	}
+	l.logger.Warn(
+		"rebuilt segment index from its store",
+		zap.Uint64("segment", s.baseOffset),
+		zap.Uint64("dropped_records", records),
+		zap.Uint64("dropped_bytes", bytes),
+	)
+	return nil
+}
+
+// segmentOffsets returns the base offsets, in ascending order, of the
+// segments in the log's directory. Every segment has a store named after its
+// base offset, its indexes are rebuilt if they're missing. Indexes without a
+// store are removed and any other file is ignored.
+func (l *Log) segmentOffsets() ([]uint64, error) {
+	files, err := ioutil.ReadDir(l.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	stores := make(map[uint64]bool)
+	var indexes []string
+	for _, file := range files {
+		name := file.Name()
+		if name == lockFile || name == producersFile {
+			continue
+		}
+		// Offloaded segments cached by a previous run are stale, the cache
+		// starts empty. It's in use if the log is set up again by a restore.
+		if name == remoteDir {
+			if l.cache != nil || l.Config.ReadOnly {
+				continue
+			}
+			if err = os.RemoveAll(path.Join(l.Dir, name)); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		// Leftovers of an interrupted compaction, restore, rebuild, index migration or producers snapshot.
+		if name == compactDir || name == restoreDir || name == rebuildDir || name == producersTmp || strings.HasSuffix(name, migrateExt) {
+			if l.Config.ReadOnly {
+				continue
+			}
+			if err = os.RemoveAll(path.Join(l.Dir, name)); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		off, ext, ok := parseSegmentFile(name)
+		if !ok || file.IsDir() {
+			l.logger.Warn("ignoring unknown file in log directory", zap.String("file", name))
+			continue
+		}
+		if ext == ".store" {
+			stores[off] = true
+		} else {
+			indexes = append(indexes, name)
+		}
+	}
+
+	// An index can't be used without its store, and it would be mistaken for
+	// the index of a new segment with the same base offset.
+	for _, name := range indexes {
+		if off, _, _ := parseSegmentFile(name); stores[off] {
+			continue
+		}
+		if l.Config.ReadOnly {
+			l.logger.Warn("ignoring index without a store", zap.String("file", name))
+			continue
+		}
+		if err = os.Remove(path.Join(l.Dir, name)); err != nil {
+			return nil, err
+		}
+		l.logger.Warn("removed index without a store", zap.String("file", name))
+	}
+
+	baseOffsets := make([]uint64, 0, len(stores))
+	for off := range stores {
+		baseOffsets = append(baseOffsets, off)
+	}
+	sort.Slice(baseOffsets, func(i, j int) bool {
+		return baseOffsets[i] < baseOffsets[j]
+	})
+	return baseOffsets, nil
+}
+
+// parseSegmentFile returns the base offset and extension of a segment file's
+// name. The returned bool is false if the name isn't one of a segment file.
+func parseSegmentFile(name string) (uint64, string, bool) {
+	ext := path.Ext(name)
+	known := false
+	for _, e := range segmentExts {
+		known = known || e == ext
+	}
+	if !known {
+		return 0, "", false
+	}
+	offStr := strings.TrimSuffix(name, ext)
+	off, err := strconv.ParseUint(offStr, 10, 64)
+	// Only the names the segments are created with, "007.store" isn't one.
+	if err != nil || strconv.FormatUint(off, 10) != offStr {
+		return 0, "", false
+	}
+	return off, ext, true
+}
+
+// RebuildIndex discards the index of the segment with the given base offset and
+// rebuilds it by scanning the records in its store. Reads don't take the lock,
+// the ones that run while the index is rebuilt may not find its records.
+func (l *Log) RebuildIndex(baseOffset uint64) error {
+	if l.Config.ReadOnly {
+		return ErrReadOnly
+	}
 	l.mu.Lock()
 	defer l.mu.Unlock()
 
-	off, err := l.activeSegment.Append(record)
+	for i, s := range l.segments {
+		if s.baseOffset != baseOffset {
+			continue
+		}
+		var records, bytes, corrupt uint64
+		var err error
+		if s == l.activeSegment {
+			records, bytes, err = s.RebuildIndex()
+		} else {
+			records, corrupt, err = l.rebuildSealed(i)
+		}
+		if err != nil {
+			return err
+		}
+		l.logger.Info(
+			"rebuilt segment index from its store",
+			zap.Uint64("segment", s.baseOffset),
+			zap.Uint64("dropped_records", records),
+			zap.Uint64("dropped_bytes", bytes),
+			zap.Uint64("corrupt_records", corrupt),
+		)
+		return nil
+	}
+	return fmt.Errorf("no segment with base offset %d", baseOffset)
+}
+
+// rebuildSealed rebuilds the indexes of the sealed segment at position i next
+// to a copy of its store and swaps them in its place, the store itself isn't
+// changed. The files of the sealed segment are mapped by the reads in progress
+// so they're replaced instead of written to. Returns the number of index
+// entries that were dropped and of corrupt records.
+func (l *Log) rebuildSealed(i int) (records, corrupt uint64, err error) {
+	if l.segments[i].closed {
+		if err = l.reopen(i); err != nil {
+			return 0, 0, err
+		}
+	}
+	s := l.segments[i]
+	dir := path.Join(l.Dir, rebuildDir)
+	if err = os.MkdirAll(dir, 0755); err != nil {
+		return 0, 0, err
+	}
+	defer os.RemoveAll(dir)
+
+	f, err := os.Create(path.Join(dir, path.Base(s.store.Name())))
+	if err != nil {
+		return 0, 0, err
+	}
+	if _, err = io.Copy(f, io.NewSectionReader(s.store, 0, int64(s.store.size))); err != nil {
+		f.Close()
+		return 0, 0, err
+	}
+	if err = f.Close(); err != nil {
+		return 0, 0, err
+	}
+	rebuilt, err := newSegment(dir, s.baseOffset, l.Config)
+	if err != nil {
+		return 0, 0, err
+	}
+	if corrupt, err = rebuilt.RebuildSealedIndex(); err != nil {
+		rebuilt.Close()
+		return 0, 0, err
+	}
+	if n, m := s.index.entries(), rebuilt.index.entries(); n > m {
+		records = n - m
+	}
+	if err = rebuilt.Close(); err != nil {
+		return 0, 0, err
+	}
+
+	// The indexes are rebuilt on startup if the process stops before both are renamed.
+	for _, name := range []string{s.index.Name(), s.timeIndex.Name()} {
+		if err = os.Rename(path.Join(dir, path.Base(name)), name); err != nil {
+			return 0, 0, err
+		}
+	}
+	sealed, err := newSegment(l.Dir, s.baseOffset, l.sealedConfig())
 	if err != nil {
+		return 0, 0, err
+	}
+	l.segments[i] = sealed
+	l.publish()
+	return records, corrupt, s.Close()
+}
+
+// Append adds the record to the log and returns its offset once it's as
+// durable as the config asks for. Records with a producer id are checked
+// against the producer's last sequences, see RegisterProducer.
+func (l *Log) Append(record *api.Record) (uint64, error) {
+	return l.appendRecord(nil, record)
+}
+
+// AppendIf adds the record to the log only if the log's next offset is still
+// expectedNext, so writers can tell when another one appended first. An
+// api.ErrOffsetConflict is returned if it isn't and nothing is appended.
+func (l *Log) AppendIf(expectedNext uint64, record *api.Record) (uint64, error) {
+	return l.appendRecord(func(next uint64) error {
+		if next != expectedNext {
+			return api.ErrOffsetConflict{Expected: expectedNext, Next: next}
+		}
+		return nil
+	}, record)
+}
+
+// appendRecord appends the record, as long as check doesn't fail if it's set,
+// and returns its offset once it's committed. If the record was written but
+// the segment couldn't be rolled after it, it's committed before the error is
+// returned along with its offset.
+func (l *Log) appendRecord(check func(next uint64) error, record *api.Record) (uint64, error) {
+	off, n, sync, err := l.append(check, record)
+	if n == 0 {
 		return 0, err
 	}
+	// The write lock is released before committing so concurrent appends can
+	// be committed together.
+	if cerr := l.commit(off, sync); cerr != nil {
+		return 0, cerr
+	}
+	l.notifyAppended()
+	return off, err
+}
 
+// AppendBatch adds the records to the log with contiguous offsets and returns
+// the offset of the first one. The records are committed together once the
+// last one is written. If the batch fails partway, the records written before
+// the failure are committed and an api.ErrPartialBatch with how many there
+// are is returned. Records of idempotent producers can't be part of a batch.
+func (l *Log) AppendBatch(records []*api.Record) (uint64, error) {
+	if len(records) == 0 {
+		l.mu.RLock()
+		defer l.mu.RUnlock()
+		return l.activeSegment.nextOffset, nil
+	}
+	for _, record := range records {
+		if record.ProducerId != 0 {
+			return 0, ErrProducerBatch
+		}
+	}
+	base, n, sync, err := l.append(nil, records...)
+	if n == 0 {
+		return 0, err
+	}
+	if cerr := l.commit(base+n-1, sync); cerr != nil {
+		return 0, cerr
+	}
+	l.notifyAppended()
+	if err != nil {
+		return base, api.ErrPartialBatch{BaseOffset: base, Count: n, Err: err}
+	}
+	return base, nil
+}
+
+// append writes the records to the active segment, rolling it whenever it's
+// maxed. It returns the offset of the first record, how many were written and
+// whether they must be synced to disk. If check is set, it's called with the
+// next offset while holding the lock and nothing is written if it fails. A
+// record that retries one of the last ones of its producer isn't written
+// again, the offset of the original is returned instead. If an error is
+// returned along with written records, they're part of the log and must be
+// committed all the same.
+func (l *Log) append(check func(next uint64) error, records ...*api.Record) (base, n uint64, sync bool, err error) {
+	if l.Config.ReadOnly {
+		return 0, 0, false, ErrReadOnly
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// The active segment could have gotten too old since the last append.
 	if l.activeSegment.IsMaxed() {
-		// I don't know if it's the best thing to return this error, because
-		// the core operation (appending) is correctly performed at this point.
-		// The new segment creation failed but the record was written and is now part of the active segment.
-		err = l.newSegment(off + 1)
+		if err = l.roll(l.activeSegment.nextOffset); err != nil {
+			return 0, 0, false, err
+		}
 	}
 
-	return off, err
+	// Only single records can come from a producer, AppendBatch turns them down.
+	if len(records) == 1 && records[0].ProducerId != 0 {
+		off, retry, err := l.producers.check(records[0])
+		if err != nil {
+			return 0, 0, false, err
+		}
+		if retry {
+			// The original append might still be committing it.
+			sync = l.Config.Durability.Mode == SyncAlways
+			return off, 1, sync, nil
+		}
+	}
+
+	base = l.activeSegment.nextOffset
+	if check != nil {
+		if err = check(base); err != nil {
+			return 0, 0, false, err
+		}
+	}
+	for _, record := range records {
+		last, err := l.activeSegment.Append(record)
+		if err != nil {
+			return base, n, l.needsSync(n), err
+		}
+		n++
+		l.producers.appended(record)
+
+		if l.activeSegment.IsMaxed() {
+			// The new segment creation failed but the record was written and
+			// is now part of the active segment.
+			if err = l.roll(last + 1); err != nil {
+				return base, n, l.needsSync(n), err
+			}
+		}
+	}
+	sync = l.needsSync(n)
+
+	return base, n, sync, nil
 }
 
+// Read returns the record at the given offset. If the offset was removed by
+// compaction, the next record that survived it is returned instead. Reads
+// don't take the lock, they go through the last published view of the segments.
 func (l *Log) Read(off uint64) (*api.Record, error) {
-	l.mu.RLock()
-	defer l.mu.RUnlock()
-	var s *segment
-	for _, segsegment := range l.segments {
-		if segsegment.baseOffset <= off && off < segsegment.nextOffset {
-			s = segsegment
-			break
+	v := l.loadView()
+	if off < v.segments[0].baseOffset && len(v.remote) > 0 && off >= v.remote[0].BaseOffset {
+		record, err := v.readRemote(off)
+		if err != io.EOF {
+			return record, err
+		}
+		// The rest of the offloaded segments were compacted, move on to the local ones.
+		v = l.loadView()
+		if base := v.segments[0].baseOffset; off < base {
+			off = base
 		}
 	}
-	// The condition after the of shouldn't matter because we check for this in the for.
-	// The code comes from the book.
-	if s == nil || s.nextOffset <= off {
-		return nil, api.ErrOffsetOutOfRange{Offset: off}
+	return l.read(v, off)
+}
+
+// OffsetForTime returns the offset of the first record appended at or after t.
+// If every record was appended before t, the offset of the next record to be appended is returned.
+func (l *Log) OffsetForTime(t time.Time) (uint64, error) {
+	// Offloaded segments are fetched without holding the lock.
+	if v := l.loadView(); len(v.remote) > 0 && v.remote[len(v.remote)-1].LastAppendTime >= t.UnixNano() {
+		off, ok, err := v.offsetForTimeRemote(t)
+		if err != nil || ok {
+			return off, err
+		}
 	}
-	return s.Read(off)
+
+	var off uint64
+	err := l.retry(l.loadView(), func(v *segmentView) error {
+		for _, s := range v.segments {
+			if s.lastAppendTime() < t.UnixNano() {
+				continue
+			}
+			s, err := l.open(s)
+			if err != nil {
+				return err
+			}
+			var ok bool
+			off, ok = s.OffsetForTime(t)
+			l.release(s)
+			if ok {
+				return nil
+			}
+		}
+		off = v.segments[len(v.segments)-1].loadNextOffset()
+		return nil
+	})
+	return off, err
+}
+
+// ReadByTime returns the first record appended at or after t.
+func (l *Log) ReadByTime(t time.Time) (*api.Record, error) {
+	off, err := l.OffsetForTime(t)
+	if err != nil {
+		return nil, err
+	}
+	return l.Read(off)
+}
+
+// start launches the background goroutines enabled in the config.
+func (l *Log) start() {
+	l.done = make(chan struct{})
+	// Every background goroutine writes to the log.
+	if l.Config.ReadOnly {
+		return
+	}
+	if l.Config.Compaction.Interval > 0 {
+		l.wg.Add(1)
+		go l.compactLoop()
+	}
+	if l.Config.Retention.MaxBytes > 0 || l.Config.Retention.MaxAge > 0 {
+		l.wg.Add(1)
+		go l.retentionLoop()
+	}
+	if l.Config.Durability.Mode == SyncInterval {
+		l.wg.Add(1)
+		go l.syncLoop()
+	}
+	if l.Config.Segment.MaxSegmentAge > 0 {
+		l.wg.Add(1)
+		go l.rollLoop()
+	}
+	if l.Config.Tiering.Store != nil && l.Config.Tiering.MaxLocalAge > 0 {
+		l.wg.Add(1)
+		go l.tierLoop()
+	}
+}
+
+// stop signals the background goroutines to finish and waits for them.
+func (l *Log) stop() {
+	if l.done == nil {
+		return
+	}
+	close(l.done)
+	l.wg.Wait()
+	l.done = nil
 }
 
 func (l *Log) Close() error {
+	if err := l.closeSegments(); err != nil {
+		return err
+	}
+	return l.unlock()
+}
+
+// closeSegments stops the background goroutines and closes every segment.
+func (l *Log) closeSegments() error {
+	l.stop()
+
 	l.mu.Lock()
 	defer l.mu.Unlock()
 
+	// Save replaying the producers from the active segment on the next startup.
+	if !l.closed && !l.Config.ReadOnly {
+		if err := l.snapshotProducers(); err != nil {
+			return err
+		}
+	}
+	l.closed = true
+
 	for _, segment := range l.segments {
 		if err := segment.Close(); err != nil {
 			return err
 		}
 	}
+	if l.cache != nil {
+		if err := l.cache.close(); err != nil {
+			return err
+		}
+		l.cache = nil
+	}
 	return nil
 }
 
 func (l *Log) Remove() error {
-	if err := l.Close(); err != nil {
+	if l.Config.ReadOnly {
+		return ErrReadOnly
+	}
+	if err := l.closeSegments(); err != nil {
+		return err
+	}
+	for _, rs := range l.remote {
+		if err := deleteRemote(l.Config.Tiering.Store, rs); err != nil {
+			return err
+		}
+	}
+	// Keep the directory locked until it's gone.
+	if err := os.RemoveAll(l.Dir); err != nil {
 		return err
 	}
-	return os.RemoveAll(l.Dir)
+	return l.unlock()
 }
 
 func (l *Log) Replace() error {
 	if err := l.Remove(); err != nil {
 		return err
 	}
-	return l.setup()
+	if err := os.MkdirAll(l.Dir, 0755); err != nil {
+		return err
+	}
+	if err := l.lock(); err != nil {
+		return err
+	}
+	l.segments = nil
+	if err := l.setup(); err != nil {
+		return err
+	}
+	l.start()
+	return nil
 }
 
+// LowestOffset returns the offset of the first record of the log, including
+// the ones offloaded to the object store.
 func (l *Log) LowestOffset() (uint64, error) {
 	l.mu.RLock()
 	defer l.mu.RUnlock()
-	return l.segments[0].baseOffset, nil
+	return l.lowestOffset(), nil
+}
+
+// lowestOffset returns the offset of the first record of the log. It must be called while holding the lock.
+func (l *Log) lowestOffset() uint64 {
+	if len(l.remote) > 0 {
+		return l.remote[0].BaseOffset
+	}
+	return l.segments[0].baseOffset
 }
 
 func (l *Log) HighestOffset() (uint64, error) {
@@ -165,43 +780,80 @@ func (l *Log) HighestOffset() (uint64, error) {
 }
 
 func (l *Log) Truncate(lowest uint64) error {
+	if l.Config.ReadOnly {
+		return ErrReadOnly
+	}
+	remote, err := l.truncate(lowest)
+	// Offloaded segments already left the log, their objects are deleted
+	// without holding the lock since the object store can take a while.
+	for _, rs := range remote {
+		if derr := deleteRemote(l.Config.Tiering.Store, rs); derr != nil {
+			return derr
+		}
+	}
+	return err
+}
+
+// truncate removes the segments before lowest from the log and deletes the
+// local ones. It returns the offloaded ones that were removed, whose objects
+// must still be deleted.
+func (l *Log) truncate(lowest uint64) ([]remoteSegment, error) {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 
-	var segments []*segment
+	var remote []remoteSegment
+	for len(l.remote) > 0 && l.remote[0].NextOffset <= lowest {
+		remote = append(remote, l.remote[0])
+		l.remote = l.remote[1:]
+	}
+
+	var segments, removed []*segment
 	for _, s := range l.segments {
-		// Remove any segments whose highest offset is smaller than the lowest to truncate.
-		if s.nextOffset <= lowest {
-			if err := s.Remove(); err != nil {
-				return err
-			}
+		// Remove any segments whose highest offset is smaller than the lowest
+		// to truncate. The active segment is kept so the log always has one.
+		if s.nextOffset <= lowest && s != l.activeSegment {
+			removed = append(removed, s)
 			continue
 		}
 		segments = append(segments, s)
 	}
 	l.segments = segments
-	return nil
+	// Reads in progress keep the removed segments open until they're done.
+	l.publish()
+	for _, s := range removed {
+		if err := s.Remove(); err != nil {
+			return remote, err
+		}
+	}
+	return remote, nil
 }
 
 // Reader returns a reader that allows to read all the log records subsequently even though they're in different segments.
 func (l *Log) Reader() io.Reader {
-	l.mu.RLock()
-	defer l.mu.RUnlock()
-
-	readers := make([]io.Reader, len(l.segments))
-	for i, segment := range l.segments {
-		readers[i] = &originReader{segment.store, 0}
+	v := l.loadView()
+	readers := make([]io.Reader, len(v.segments))
+	for i, segment := range v.segments {
+		readers[i] = &originReader{log: l, segment: segment}
 	}
 	return io.MultiReader(readers...)
 }
 
+// originReader reads the store of a segment from its start, opening the
+// segment for every read in case its files were closed in between.
 type originReader struct {
-	*store
-	off int64
+	log     *Log
+	segment *segment
+	off     int64
 }
 
 func (o *originReader) Read(p []byte) (int, error) {
-	n, err := o.ReadAt(p, o.off)
+	s, err := o.log.open(o.segment)
+	if err != nil {
+		return 0, err
+	}
+	o.segment = s
+	n, err := s.store.ReadAt(p, o.off)
+	o.log.release(s)
 	o.off += int64(n)
 	return n, err
 }
@@ -213,5 +865,6 @@ func (l *Log) newSegment(off uint64) error {
 	}
 	l.segments = append(l.segments, s)
 	l.activeSegment = s
+	l.publish()
 	return nil
 }
@@ -1,10 +1,14 @@
 package log
 
 import (
+	"context"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
+	"path"
 	"testing"
+	"time"
 
 	api "github.com/AYM1607/proglog/api/v1"
 	"github.com/stretchr/testify/require"
@@ -20,6 +24,15 @@ func TestLog(t *testing.T) {
 		"init with existing segments":      testInitExisting,
 		"reader":                           testReader,
 		"truncate":                         testTruncate,
+		"corrupt record error":             testCorruptRecordErr,
+		"recover torn tail":                testRecoverTornTail,
+		"rebuild index":                    testRebuildIndex,
+		"read by time":                     testReadByTime,
+		"append batch":                     testAppendBatch,
+		"append partial batch":             testAppendPartialBatch,
+		"append if":                        testAppendIf,
+		"discover segments":                testDiscoverSegments,
+		"seal segments":                    testSealSegments,
 	} {
 		t.Run(scenario, func(t *testing.T) {
 			dir, err := ioutil.TempDir("", "log-test")
@@ -37,6 +50,139 @@ func TestLog(t *testing.T) {
 	}
 }
 
+func TestLegacyLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "log-legacy-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// A log written before stores had a header: 5 records in 2 segments.
+	var stores []string
+	for _, seg := range []struct{ base, n uint64 }{{0, 3}, {3, 2}} {
+		var records [][]byte
+		for off := seg.base; off < seg.base+seg.n; off++ {
+			b, err := proto.Marshal(&api.Record{Value: []byte("hello world"), Offset: off})
+			require.NoError(t, err)
+			records = append(records, b)
+		}
+		stores = append(stores, writeLegacySegment(t, dir, seg.base, records))
+	}
+	sizes := make([]int64, len(stores))
+	for i, name := range stores {
+		fi, err := os.Stat(name)
+		require.NoError(t, err)
+		sizes[i] = fi.Size()
+	}
+
+	log, err := NewLog(dir, Config{})
+	require.NoError(t, err)
+	for off := uint64(0); off < 5; off++ {
+		read, err := log.Read(off)
+		require.NoError(t, err, "Records in the old format should be readable.")
+		require.Equal(t, off, read.Offset)
+		require.Equal(t, []byte("hello world"), read.Value)
+	}
+	require.Len(t, log.segments, 3, "The old active segment should be sealed.")
+	require.False(t, log.activeSegment.store.legacy)
+	off, err := log.Append(&api.Record{Value: []byte("hello world")})
+	require.NoError(t, err)
+	require.Equal(t, uint64(5), off)
+	require.NoError(t, log.Close())
+
+	for i, name := range stores {
+		fi, err := os.Stat(name)
+		require.NoError(t, err)
+		require.Equal(t, sizes[i], fi.Size(), "Stores in the old format shouldn't be changed.")
+	}
+	log, err = NewLog(dir, Config{})
+	require.NoError(t, err)
+	defer log.Close()
+	for off := uint64(0); off < 6; off++ {
+		read, err := log.Read(off)
+		require.NoError(t, err)
+		require.Equal(t, off, read.Offset)
+	}
+}
+
+func TestCorruptSealedSegment(t *testing.T) {
+	dir, err := ioutil.TempDir("", "log-corrupt-sealed-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := Config{}
+	c.Segment.MaxIndexBytes = entWidth * 3
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+	appendRecords(t, log, 5)
+	s := log.segments[0]
+	_, pos, err := s.index.Read(-1)
+	require.NoError(t, err)
+	require.NoError(t, log.Close())
+
+	// Damage the data of the last record of the sealed segment and lose its index.
+	f, err := os.OpenFile(s.store.Name(), os.O_WRONLY, 0644)
+	require.NoError(t, err)
+	_, err = f.WriteAt([]byte{0}, int64(pos+headerWidth))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	require.NoError(t, os.Remove(s.index.Name()))
+
+	for i := 0; i < 2; i++ {
+		log, err = NewLog(dir, c)
+		require.NoError(t, err)
+		require.Equal(t, s.store.size, log.segments[0].storeSize(), "Sealed stores shouldn't be truncated.")
+		for _, off := range []uint64{0, 1, 3, 4} {
+			read, err := log.Read(off)
+			require.NoError(t, err)
+			require.Equal(t, off, read.Offset)
+		}
+		_, err = log.Read(2)
+		require.Equal(t, api.ErrCorruptRecord{Segment: 0, Offset: 2}, err)
+		require.NoError(t, log.Close())
+	}
+
+	// A sealed store that was cut short can't be opened.
+	require.NoError(t, os.Truncate(s.store.Name(), int64(s.store.size-3)))
+	require.NoError(t, os.Remove(s.index.Name()))
+	_, err = NewLog(dir, c)
+	require.Error(t, err)
+}
+
+// writeLegacySegment writes a segment as it was before stores had a header
+// and records a checksum: records framed by their length, an index with 32-bit
+// relative offsets and no time index. It returns the name of the store.
+func writeLegacySegment(t *testing.T, dir string, base uint64, records [][]byte) string {
+	t.Helper()
+	var store, index []byte
+	for i, p := range records {
+		ent := make([]byte, legacyEntWidth)
+		enc.PutUint32(ent, uint32(i))
+		enc.PutUint64(ent[legacyOffWidth:], uint64(len(store)))
+		index = append(index, ent...)
+
+		frame := make([]byte, lenWidth)
+		enc.PutUint64(frame, uint64(len(p)))
+		store = append(append(store, frame...), p...)
+	}
+	name := path.Join(dir, fmt.Sprintf("%d.store", base))
+	require.NoError(t, ioutil.WriteFile(name, store, 0644))
+	require.NoError(t, ioutil.WriteFile(path.Join(dir, fmt.Sprintf("%d.index", base)), index, 0644))
+	return name
+}
+
+func TestMaxIndexBytes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "log-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := Config{}
+	c.Segment.MaxIndexBytes = entWidth - 1
+	_, err = NewLog(dir, c)
+	require.Error(t, err, "Indexes must hold at least one entry.")
+	c.Segment.MaxIndexBytes = maxIndexBytes + 1
+	_, err = NewLog(dir, c)
+	require.Error(t, err, "Indexes must fit in memory.")
+}
+
 func testAppendRead(t *testing.T, log *Log) {
 	apnd := &api.Record{
 		Value: []byte("hello world"),
@@ -55,6 +201,16 @@ func testOutOfRangeErr(t *testing.T, log *Log) {
 	require.Nil(t, read)
 	apiErr := err.(api.ErrOffsetOutOfRange)
 	require.Equal(t, uint64(1), apiErr.Offset)
+	require.False(t, apiErr.Truncated())
+
+	// Every segment holds a single record, truncating removes the first one.
+	appendRecords(t, log, 3)
+	require.NoError(t, log.Truncate(1))
+	_, err = log.Read(0)
+	apiErr = err.(api.ErrOffsetOutOfRange)
+	require.True(t, apiErr.Truncated(), "Offsets below the lowest one were removed.")
+	require.Equal(t, uint64(1), apiErr.Lowest)
+	require.Equal(t, uint64(2), apiErr.Highest)
 }
 
 func testInitExisting(t *testing.T, o *Log) {
@@ -102,8 +258,10 @@ func testReader(t *testing.T, log *Log) {
 	require.NoError(t, err)
 
 	read := &api.Record{}
-	// Store writes the length as a prefix to the binary content so we have to skip it.
-	err = proto.Unmarshal(b[lenWidth:], read)
+	// Store starts with its header and writes the length and checksum as a
+	// prefix to the binary content so we have to skip them.
+	n := enc.Uint64(b[storeHeaderWidth:])
+	err = proto.Unmarshal(b[storeHeaderWidth+headerWidth:storeHeaderWidth+headerWidth+n], read)
 	require.NoError(t, err)
 	require.Equal(t, apnd.Value, read.Value)
 }
@@ -124,4 +282,290 @@ func testTruncate(t *testing.T, log *Log) {
 
 	_, err = log.Read(0)
 	require.Error(t, err)
+
+	// Truncating past the highest offset keeps the active segment.
+	require.NoError(t, log.Truncate(10))
+	_, err = log.Read(3)
+	require.Equal(t, api.ErrOffsetOutOfRange{Offset: 3, Lowest: 3, Highest: 2}, err)
+	off, err := log.Append(apnd)
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), off)
+}
+
+func testCorruptRecordErr(t *testing.T, log *Log) {
+	apnd := &api.Record{
+		Value: []byte("hello world"),
+	}
+
+	for i := 0; i < 2; i++ {
+		_, err := log.Append(apnd)
+		require.NoError(t, err)
+	}
+
+	// Damage the data of the second record, which lives in its own segment.
+	s := log.segments[1]
+	_, pos, err := s.index.Read(0)
+	require.NoError(t, err)
+	require.NoError(t, s.store.buf.Flush())
+	f, err := os.OpenFile(s.store.Name(), os.O_WRONLY, 0644)
+	require.NoError(t, err)
+	_, err = f.WriteAt([]byte{0}, int64(pos+headerWidth))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	_, err = log.Read(0)
+	require.NoError(t, err, "Records in other segments are still readable.")
+
+	read, err := log.Read(1)
+	require.Nil(t, read)
+	apiErr := err.(api.ErrCorruptRecord)
+	require.Equal(t, uint64(1), apiErr.Segment)
+	require.Equal(t, uint64(1), apiErr.Offset)
+}
+
+func testRecoverTornTail(t *testing.T, o *Log) {
+	apnd := &api.Record{
+		Value: []byte("hello world"),
+	}
+
+	for i := 0; i < 2; i++ {
+		_, err := o.Append(apnd)
+		require.NoError(t, err)
+	}
+	require.NoError(t, o.Close())
+
+	// Simulate a crash while the next record was being written to the active
+	// segment: its index entry made it to disk but only part of the record did.
+	active := o.segments[len(o.segments)-1]
+	b, err := proto.Marshal(apnd)
+	require.NoError(t, err)
+	f, err := os.OpenFile(active.store.Name(), os.O_WRONLY|os.O_APPEND, 0644)
+	require.NoError(t, err)
+	_, err = f.Write(b[:len(b)/2])
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	require.NoError(t, os.Truncate(active.index.Name(), int64(indexHeaderWidth+entWidth)))
+
+	n, err := NewLog(o.Dir, o.Config)
+	require.NoError(t, err)
+
+	off, err := n.HighestOffset()
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), off, "The torn record should be dropped.")
+
+	off, err = n.Append(apnd)
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), off)
+	read, err := n.Read(off)
+	require.NoError(t, err)
+	require.Equal(t, apnd.Value, read.Value)
+}
+
+func testRebuildIndex(t *testing.T, o *Log) {
+	apnd := &api.Record{
+		Value: []byte("hello world"),
+	}
+
+	for i := 0; i < 3; i++ {
+		_, err := o.Append(apnd)
+		require.NoError(t, err)
+	}
+	require.NoError(t, o.Close())
+
+	// Lose the index of a sealed segment.
+	require.NoError(t, os.Remove(o.segments[1].index.Name()))
+
+	n, err := NewLog(o.Dir, o.Config)
+	require.NoError(t, err)
+	for off := uint64(0); off < 3; off++ {
+		read, err := n.Read(off)
+		require.NoError(t, err, "Records should be readable after the index is rebuilt.")
+		require.Equal(t, off, read.Offset)
+	}
+
+	require.NoError(t, n.RebuildIndex(2))
+	read, err := n.Read(2)
+	require.NoError(t, err)
+	require.Equal(t, apnd.Value, read.Value)
+
+	require.Error(t, n.RebuildIndex(42), "Only existing segments can be rebuilt.")
+}
+
+func testReadByTime(t *testing.T, log *Log) {
+	apnd := &api.Record{
+		Value: []byte("hello world"),
+	}
+
+	_, err := log.Append(apnd)
+	require.NoError(t, err)
+	first, err := log.Read(0)
+	require.NoError(t, err)
+
+	// Make sure the next records are appended strictly later.
+	time.Sleep(time.Millisecond)
+	start := time.Now()
+	for i := 0; i < 2; i++ {
+		_, err = log.Append(apnd)
+		require.NoError(t, err)
+	}
+
+	off, err := log.OffsetForTime(time.Unix(0, first.AppendTime))
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), off)
+
+	read, err := log.ReadByTime(start)
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), read.Offset, "The first record after the start time lives in another segment.")
+	require.GreaterOrEqual(t, read.AppendTime, start.UnixNano())
+
+	off, err = log.OffsetForTime(time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), off, "Times after the last record resolve to the next offset.")
+	_, err = log.ReadByTime(time.Now().Add(time.Hour))
+	require.Equal(t, api.ErrOffsetOutOfRange{Offset: 3, Highest: 2}, err)
+}
+
+func testAppendBatch(t *testing.T, log *Log) {
+	_, err := log.Append(&api.Record{Value: []byte("hello world")})
+	require.NoError(t, err)
+
+	// Every segment holds a single record so the batch rolls over on every one.
+	batch := []*api.Record{
+		{Value: []byte("first")},
+		{Value: []byte("second")},
+		{Value: []byte("third")},
+	}
+	base, err := log.AppendBatch(batch)
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), base)
+	require.Len(t, log.segments, 5)
+
+	for i, want := range batch {
+		read, err := log.Read(base + uint64(i))
+		require.NoError(t, err)
+		require.Equal(t, base+uint64(i), read.Offset)
+		require.Equal(t, want.Value, read.Value)
+	}
+
+	base, err = log.AppendBatch(nil)
+	require.NoError(t, err)
+	require.Equal(t, uint64(4), base, "An empty batch should return the next offset.")
+}
+
+func testAppendPartialBatch(t *testing.T, log *Log) {
+	// The segment after the second record of the batch can't be created.
+	require.NoError(t, os.Mkdir(path.Join(log.Dir, "2.store"), 0755))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	waited := make(chan error)
+	go func() {
+		waited <- log.Wait(ctx, 1)
+	}()
+
+	batch := []*api.Record{
+		{Value: []byte("first")},
+		{Value: []byte("second")},
+		{Value: []byte("third")},
+	}
+	_, err := log.AppendBatch(batch)
+	partial, ok := err.(api.ErrPartialBatch)
+	require.True(t, ok, "got %v", err)
+	require.Equal(t, uint64(0), partial.BaseOffset)
+	require.Equal(t, uint64(2), partial.Count)
+	require.Error(t, partial.Err)
+
+	// The records written before the failure are committed.
+	require.NoError(t, <-waited, "Waiters should be told about the appended records.")
+	for i, want := range batch[:2] {
+		read, err := log.Read(uint64(i))
+		require.NoError(t, err)
+		require.Equal(t, want.Value, read.Value)
+	}
+	off, err := log.HighestOffset()
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), off)
+}
+
+func testAppendIf(t *testing.T, log *Log) {
+	off, err := log.AppendIf(0, &api.Record{Value: []byte("first")})
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), off)
+
+	_, err = log.AppendIf(0, &api.Record{Value: []byte("second")})
+	require.Equal(t, api.ErrOffsetConflict{Expected: 0, Next: 1}, err)
+	_, err = log.Read(1)
+	require.Error(t, err, "Nothing should be appended on a conflict.")
+
+	off, err = log.AppendIf(1, &api.Record{Value: []byte("second")})
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), off)
+}
+
+func testDiscoverSegments(t *testing.T, o *Log) {
+	appendRecords(t, o, 3)
+	require.NoError(t, o.Close())
+
+	// Files that aren't part of a segment are ignored.
+	for _, name := range []string{"notes.txt", "007.store", "abc.index", "1.store.bak"} {
+		require.NoError(t, ioutil.WriteFile(path.Join(o.Dir, name), []byte("hello world"), 0644))
+	}
+	// Indexes without a store are removed.
+	for _, name := range []string{"100.index", "100.timeindex"} {
+		require.NoError(t, ioutil.WriteFile(path.Join(o.Dir, name), make([]byte, entWidth), 0644))
+	}
+	// A store without an index gets its index rebuilt.
+	require.NoError(t, os.Remove(path.Join(o.Dir, "1.index")))
+
+	n, err := NewLog(o.Dir, o.Config)
+	require.NoError(t, err)
+	require.Len(t, n.segments, 4)
+	read, err := n.Read(1)
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), read.Offset)
+	_, err = os.Stat(path.Join(o.Dir, "100.index"))
+	require.True(t, os.IsNotExist(err), "Indexes without a store should be removed.")
+	_, err = os.Stat(path.Join(o.Dir, "notes.txt"))
+	require.NoError(t, err)
+	require.NoError(t, n.Close())
+
+	// Segments that can't be opened make the log fail to open.
+	require.NoError(t, os.Remove(path.Join(o.Dir, "2.index")))
+	require.NoError(t, os.Mkdir(path.Join(o.Dir, "2.index"), 0755))
+	_, err = NewLog(o.Dir, o.Config)
+	require.Error(t, err)
+}
+
+func testSealSegments(t *testing.T, o *Log) {
+	appendRecords(t, o, 3)
+
+	// Only the active segment keeps a write handle.
+	for _, s := range o.segments[:len(o.segments)-1] {
+		require.True(t, s.config.ReadOnly)
+		require.NotNil(t, s.store.mmap, "Sealed stores should be mapped to memory.")
+	}
+	active := o.activeSegment
+	require.False(t, active.config.ReadOnly)
+	require.Nil(t, active.store.mmap)
+
+	for off := uint64(0); off < 3; off++ {
+		read, err := o.Read(off)
+		require.NoError(t, err)
+		require.Equal(t, off, read.Offset)
+	}
+	b, err := ioutil.ReadAll(o.Reader())
+	require.NoError(t, err)
+	require.NotEmpty(t, b)
+
+	// Sealed segments are found sealed again on restart.
+	require.NoError(t, o.Close())
+	n, err := NewLog(o.Dir, o.Config)
+	require.NoError(t, err)
+	defer n.Close()
+	require.NotNil(t, n.segments[0].store.mmap)
+	require.NoError(t, n.RebuildIndex(0))
+	require.NotNil(t, n.segments[0].store.mmap, "Rebuilt segments should stay sealed.")
+	read, err := n.Read(0)
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), read.Offset)
 }
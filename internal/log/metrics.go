@@ -0,0 +1,52 @@
+package log
+
+import (
+	"go.opencensus.io/stats"
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/tag"
+)
+
+var (
+	// reasonKey tags why the retention policy deleted a segment, either "size" or "age".
+	reasonKey = tag.MustNewKey("reason")
+
+	segmentsDeleted = stats.Int64(
+		"proglog/retention/segments_deleted",
+		"Number of segments deleted by the retention policy",
+		stats.UnitDimensionless,
+	)
+	bytesDeleted = stats.Int64(
+		"proglog/retention/bytes_deleted",
+		"Number of store bytes deleted by the retention policy",
+		stats.UnitBytes,
+	)
+	storeSyncs = stats.Int64(
+		"proglog/durability/store_syncs",
+		"Number of times a store was synced to disk",
+		stats.UnitDimensionless,
+	)
+
+	// Views are the metrics a log reports, they're registered when a log is created.
+	Views = []*view.View{
+		{
+			Name:        segmentsDeleted.Name(),
+			Description: segmentsDeleted.Description(),
+			Measure:     segmentsDeleted,
+			TagKeys:     []tag.Key{reasonKey},
+			Aggregation: view.Sum(),
+		},
+		{
+			Name:        bytesDeleted.Name(),
+			Description: bytesDeleted.Description(),
+			Measure:     bytesDeleted,
+			TagKeys:     []tag.Key{reasonKey},
+			Aggregation: view.Sum(),
+		},
+		{
+			Name:        storeSyncs.Name(),
+			Description: storeSyncs.Description(),
+			Measure:     storeSyncs,
+			Aggregation: view.Count(),
+		},
+	}
+)
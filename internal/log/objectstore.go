@@ -0,0 +1,88 @@
+package log
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+)
+
+// ObjectStore holds the segments offloaded from a log. Objects are written
+// once and never modified, so stores without appends or renames, like S3,
+// can implement it.
+type ObjectStore interface {
+	// Put writes the object with the given name, replacing any existing one.
+	Put(name string, r io.Reader) error
+	// Get returns the contents of the object with the given name. It returns
+	// an error that satisfies os.IsNotExist if there's no such object.
+	Get(name string) (io.ReadCloser, error)
+	// Delete removes the object with the given name, if there's one.
+	Delete(name string) error
+	// List returns the names of every object in the store.
+	List() ([]string, error)
+}
+
+// LocalObjectStore is an object store backed by a directory of the local filesystem.
+type LocalObjectStore struct {
+	Dir string
+}
+
+var _ ObjectStore = (*LocalObjectStore)(nil)
+
+// NewLocalObjectStore returns an object store that keeps its objects in dir, creating it if needed.
+func NewLocalObjectStore(dir string) (*LocalObjectStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &LocalObjectStore{Dir: dir}, nil
+}
+
+func (s *LocalObjectStore) Put(name string, r io.Reader) error {
+	// Objects only show up once they're complete.
+	f, err := ioutil.TempFile(s.Dir, ".put-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err = io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path.Join(s.Dir, path.Base(name)))
+}
+
+func (s *LocalObjectStore) Get(name string) (io.ReadCloser, error) {
+	return os.Open(path.Join(s.Dir, path.Base(name)))
+}
+
+func (s *LocalObjectStore) Delete(name string) error {
+	err := os.Remove(path.Join(s.Dir, path.Base(name)))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func (s *LocalObjectStore) List() ([]string, error) {
+	files, err := ioutil.ReadDir(s.Dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, file := range files {
+		// Objects that are still being written.
+		if file.IsDir() || strings.HasPrefix(file.Name(), ".") {
+			continue
+		}
+		names = append(names, file.Name())
+	}
+	return names, nil
+}
@@ -0,0 +1,284 @@
+package log
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+
+	api "github.com/AYM1607/proglog/api/v1"
+	"go.uber.org/zap"
+)
+
+const (
+	// producersFile is the file, inside the log's directory, with the last
+	// snapshot of the producers' state.
+	producersFile = "producers.json"
+	// producersTmp is where the snapshot is written before it replaces the last one.
+	producersTmp = producersFile + ".tmp"
+	// producersVersion is the version of the producers' snapshot. Version 1
+	// only kept the offset of the last sequence of every producer.
+	producersVersion = 2
+	// producerWindow is how many of the last sequences of a producer are
+	// remembered, retries of any of them return their offset.
+	producerWindow = 5
+)
+
+// ErrProducerBatch is returned when a batch holds records of an idempotent
+// producer, they have to be appended one at a time.
+var ErrProducerBatch = errors.New("records of idempotent producers can't be appended in batches")
+
+// producers tracks the last records appended by every registered producer so
+// retries of them aren't appended again.
+type producers struct {
+	// nextID is the id the next producer to register gets, ids start at 1.
+	nextID uint64
+	state  map[uint64]*producerState
+}
+
+type producerState struct {
+	// next is the sequence expected from the producer, 0 if it didn't append.
+	next uint64
+	// recent holds the last sequences of the producer, up to producerWindow
+	// of them, oldest first.
+	recent []producerSequence
+}
+
+type producerSequence struct {
+	Sequence uint64 `json:"sequence"`
+	Offset   uint64 `json:"offset"`
+}
+
+func newProducers() *producers {
+	return &producers{
+		nextID: 1,
+		state:  make(map[uint64]*producerState),
+	}
+}
+
+// register returns the id of a new producer.
+func (p *producers) register() uint64 {
+	id := p.nextID
+	p.nextID++
+	p.state[id] = &producerState{}
+	return id
+}
+
+// check returns the offset the record was appended at and true if it's a retry
+// of one of the last records of its producer. An error is returned if the
+// producer isn't registered or the record's sequence is neither the next one
+// nor a recent one.
+func (p *producers) check(record *api.Record) (uint64, bool, error) {
+	st, ok := p.state[record.ProducerId]
+	if !ok {
+		return 0, false, api.ErrUnknownProducer{ProducerID: record.ProducerId}
+	}
+	for _, seq := range st.recent {
+		if seq.Sequence == record.Sequence {
+			return seq.Offset, true, nil
+		}
+	}
+	if record.Sequence != st.next {
+		return 0, false, api.ErrOutOfOrderSequence{
+			ProducerID: record.ProducerId,
+			Expected:   st.next,
+			Sequence:   record.Sequence,
+		}
+	}
+	return 0, false, nil
+}
+
+// appended moves the producer of the record past its sequence. Records read
+// back from the log could come from producers that weren't registered with
+// this state, they're added along the way.
+func (p *producers) appended(record *api.Record) {
+	if record.ProducerId == 0 {
+		return
+	}
+	st, ok := p.state[record.ProducerId]
+	if !ok {
+		st = &producerState{}
+		p.state[record.ProducerId] = st
+	}
+	st.next = record.Sequence + 1
+	st.recent = append(st.recent, producerSequence{
+		Sequence: record.Sequence,
+		Offset:   record.Offset,
+	})
+	if len(st.recent) > producerWindow {
+		st.recent = st.recent[len(st.recent)-producerWindow:]
+	}
+	if record.ProducerId >= p.nextID {
+		p.nextID = record.ProducerId + 1
+	}
+}
+
+// producersSnapshot is the state of the producers after every record before Offset.
+type producersSnapshot struct {
+	Version   int                     `json:"version"`
+	Offset    uint64                  `json:"offset"`
+	NextID    uint64                  `json:"next_id"`
+	Producers []producerSnapshotEntry `json:"producers"`
+}
+
+type producerSnapshotEntry struct {
+	ID           uint64             `json:"id"`
+	NextSequence uint64             `json:"next_sequence"`
+	Recent       []producerSequence `json:"recent,omitempty"`
+	// Offset is the offset of the last sequence in version 1 snapshots.
+	Offset uint64 `json:"offset,omitempty"`
+}
+
+// RegisterProducer returns a new producer id. Records appended with it and
+// consecutive sequence numbers, starting at 0, are only appended once: a
+// retry of one of the last few returns its offset and any other sequence
+// fails. The producers are kept across restarts.
+func (l *Log) RegisterProducer() (uint64, error) {
+	if l.Config.ReadOnly {
+		return 0, ErrReadOnly
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	id := l.producers.register()
+	// Registrations aren't part of the log, they're only kept by the snapshot.
+	if err := l.snapshotProducers(); err != nil {
+		delete(l.producers.state, id)
+		return 0, err
+	}
+	return id, nil
+}
+
+// snapshotProducers writes the state of the producers as of the next offset of
+// the log, so it's only rebuilt from the records after it on startup. Nothing
+// is written if no producer ever registered. It must be called while holding
+// the write lock.
+func (l *Log) snapshotProducers() error {
+	if l.producers.nextID == 1 {
+		return nil
+	}
+	snap := producersSnapshot{
+		Version: producersVersion,
+		Offset:  l.activeSegment.nextOffset,
+		NextID:  l.producers.nextID,
+	}
+	for id, st := range l.producers.state {
+		snap.Producers = append(snap.Producers, producerSnapshotEntry{
+			ID:           id,
+			NextSequence: st.next,
+			Recent:       st.recent,
+		})
+	}
+	sort.Slice(snap.Producers, func(i, j int) bool {
+		return snap.Producers[i].ID < snap.Producers[j].ID
+	})
+	b, err := json.Marshal(snap)
+	if err != nil {
+		return err
+	}
+
+	// Replace the last snapshot in a single step so there's always one.
+	tmp := path.Join(l.Dir, producersTmp)
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp, path.Join(l.Dir, producersFile)); err != nil {
+		return err
+	}
+	return syncDir(l.Dir)
+}
+
+// setupProducers loads the last snapshot of the producers and moves them past
+// the records appended after it. It must be called once the segments are set
+// up, while no one else uses the log.
+func (l *Log) setupProducers() error {
+	l.producers = newProducers()
+	f, err := os.Open(path.Join(l.Dir, producersFile))
+	if os.IsNotExist(err) {
+		// Producers never registered with the log.
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var snap producersSnapshot
+	if err = json.NewDecoder(f).Decode(&snap); err != nil {
+		return fmt.Errorf("read producers snapshot: %w", err)
+	}
+	if snap.Version != producersVersion && snap.Version != 1 {
+		return fmt.Errorf("unsupported producers snapshot version %d", snap.Version)
+	}
+
+	next := l.activeSegment.nextOffset
+	l.producers.nextID = snap.NextID
+	for _, e := range snap.Producers {
+		recent := e.Recent
+		if snap.Version == 1 && e.NextSequence > 0 {
+			recent = []producerSequence{{Sequence: e.NextSequence - 1, Offset: e.Offset}}
+		}
+		// The records of the producer were lost when the log recovered from a
+		// crash, it can't tell a retry from a new record anymore.
+		if n := len(recent); n > 0 && recent[n-1].Offset >= next {
+			l.logger.Warn("dropped producer ahead of the log", zap.Uint64("producer", e.ID))
+			continue
+		}
+		l.producers.state[e.ID] = &producerState{
+			next:   e.NextSequence,
+			recent: recent,
+		}
+	}
+	return l.replayProducers(snap.Offset)
+}
+
+// replayProducers moves the producers past the records of the local segments
+// at or after off. It must be called while holding the write lock or while no
+// one else uses the log.
+func (l *Log) replayProducers(off uint64) error {
+	for i := 0; i < len(l.segments); i++ {
+		if l.segments[i].nextOffset <= off {
+			continue
+		}
+		if l.segments[i].closed {
+			if err := l.reopen(i); err != nil {
+				return err
+			}
+		}
+		s := l.segments[i]
+		for off < s.nextOffset {
+			record, err := s.Read(off)
+			// The rest of the segment was compacted.
+			if err == io.EOF {
+				break
+			}
+			// Sealed segments keep their corrupt records, whichever producer
+			// appended them can't tell its retries apart anymore.
+			if e, ok := err.(api.ErrCorruptRecord); ok {
+				l.logger.Warn("skipped corrupt record replaying producers", zap.Uint64("offset", e.Offset))
+				off = e.Offset + 1
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			l.producers.appended(record)
+			off = record.Offset + 1
+		}
+	}
+	return nil
+}
@@ -0,0 +1,178 @@
+package log
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	api "github.com/AYM1607/proglog/api/v1"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIdempotentProducer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "producer-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := Config{}
+	c.Segment.MaxIndexBytes = entWidth * 2
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+
+	id, err := log.RegisterProducer()
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), id)
+	produce := func(log *Log, seq uint64) (uint64, error) {
+		return log.Append(&api.Record{
+			Value:      []byte("hello world"),
+			ProducerId: id,
+			Sequence:   seq,
+		})
+	}
+
+	off, err := produce(log, 0)
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), off)
+	appendRecords(t, log, 1)
+	off, err = produce(log, 1)
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), off)
+
+	// Retries of the last sequences are returned their offset.
+	off, err = produce(log, 1)
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), off)
+	off, err = produce(log, 0)
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), off)
+	_, err = log.Read(3)
+	require.Error(t, err, "The retries shouldn't be appended.")
+	_, err = produce(log, 3)
+	require.Equal(t, api.ErrOutOfOrderSequence{ProducerID: id, Expected: 2, Sequence: 3}, err)
+
+	_, err = log.Append(&api.Record{Value: []byte("hello world"), ProducerId: 5})
+	require.Equal(t, api.ErrUnknownProducer{ProducerID: 5}, err)
+	_, err = log.AppendBatch([]*api.Record{{Value: []byte("hello world"), ProducerId: id, Sequence: 2}})
+	require.Equal(t, ErrProducerBatch, err)
+
+	// Keep the snapshot of the last roll to replay the records after it, as
+	// if the log crashed before closing.
+	b, err := ioutil.ReadFile(path.Join(dir, producersFile))
+	require.NoError(t, err)
+	off, err = produce(log, 2)
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), off)
+	require.NoError(t, log.Close())
+
+	for _, snap := range [][]byte{nil, b} {
+		if snap != nil {
+			require.NoError(t, ioutil.WriteFile(path.Join(dir, producersFile), snap, 0644))
+		}
+		log, err = NewLog(dir, c)
+		require.NoError(t, err)
+		off, err = produce(log, 2)
+		require.NoError(t, err)
+		require.Equal(t, uint64(3), off, "Producers should survive restarts.")
+		off, err = produce(log, 0)
+		require.NoError(t, err)
+		require.Equal(t, uint64(0), off)
+		require.NoError(t, log.Close())
+	}
+
+	log, err = NewLog(dir, c)
+	require.NoError(t, err)
+	defer log.Close()
+	next, err := log.RegisterProducer()
+	require.NoError(t, err)
+	require.Equal(t, id+1, next, "Ids shouldn't be handed out twice.")
+}
+
+func TestRestoreProducers(t *testing.T) {
+	dir, err := ioutil.TempDir("", "producer-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	log, err := NewLog(dir, Config{})
+	require.NoError(t, err)
+	defer log.Close()
+	id, err := log.RegisterProducer()
+	require.NoError(t, err)
+	_, err = log.Append(&api.Record{Value: []byte("hello world"), ProducerId: id})
+	require.NoError(t, err)
+	var buf bytes.Buffer
+	require.NoError(t, log.Snapshot(&buf))
+
+	restoredDir, err := ioutil.TempDir("", "producer-restore-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(restoredDir)
+	restored, err := NewLog(restoredDir, Config{})
+	require.NoError(t, err)
+	defer restored.Close()
+	_, err = restored.RegisterProducer()
+	require.NoError(t, err)
+	require.NoError(t, restored.Restore(&buf))
+
+	// The producers come from the records of the snapshot.
+	off, err := restored.Append(&api.Record{Value: []byte("hello world"), ProducerId: id})
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), off)
+	next, err := restored.RegisterProducer()
+	require.NoError(t, err)
+	require.Equal(t, id+1, next)
+}
+
+func TestProducerWindow(t *testing.T) {
+	p := newProducers()
+	id := p.register()
+	record := func(seq uint64) *api.Record {
+		return &api.Record{ProducerId: id, Sequence: seq, Offset: seq + 10}
+	}
+	for seq := uint64(0); seq < producerWindow+2; seq++ {
+		_, retry, err := p.check(record(seq))
+		require.NoError(t, err)
+		require.False(t, retry)
+		p.appended(record(seq))
+	}
+
+	// Only the last sequences are remembered.
+	for seq := uint64(0); seq < producerWindow+2; seq++ {
+		off, retry, err := p.check(record(seq))
+		if seq < 2 {
+			require.Equal(t, api.ErrOutOfOrderSequence{ProducerID: id, Expected: producerWindow + 2, Sequence: seq}, err)
+			continue
+		}
+		require.NoError(t, err)
+		require.True(t, retry)
+		require.Equal(t, seq+10, off)
+	}
+}
+
+func TestProducersVersion1(t *testing.T) {
+	dir, err := ioutil.TempDir("", "producer-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	log, err := NewLog(dir, Config{})
+	require.NoError(t, err)
+	id, err := log.RegisterProducer()
+	require.NoError(t, err)
+	for seq := uint64(0); seq < 2; seq++ {
+		_, err = log.Append(&api.Record{Value: []byte("hello world"), ProducerId: id, Sequence: seq})
+		require.NoError(t, err)
+	}
+	require.NoError(t, log.Close())
+
+	// Version 1 snapshots only have the offset of the last sequence.
+	snap := `{"version":1,"offset":2,"next_id":2,"producers":[{"id":1,"next_sequence":2,"offset":1}]}`
+	require.NoError(t, ioutil.WriteFile(path.Join(dir, producersFile), []byte(snap), 0644))
+	log, err = NewLog(dir, Config{})
+	require.NoError(t, err)
+	defer log.Close()
+	off, err := log.Append(&api.Record{Value: []byte("hello world"), ProducerId: id, Sequence: 1})
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), off)
+	_, err = log.Append(&api.Record{Value: []byte("hello world"), ProducerId: id, Sequence: 0})
+	require.Equal(t, api.ErrOutOfOrderSequence{ProducerID: id, Expected: 2, Sequence: 0}, err)
+}
@@ -0,0 +1,138 @@
+package log
+
+import (
+	"context"
+	"time"
+
+	"go.opencensus.io/stats"
+	"go.opencensus.io/tag"
+	"go.uber.org/zap"
+)
+
+// defaultRetentionInterval is how often the retention limits are checked if the config doesn't say.
+const defaultRetentionInterval = time.Minute
+
+// retain deletes the oldest sealed segments that fall outside the retention
+// limits. The active segment is never deleted.
+func (l *Log) retain() error {
+	remote, reasons, err := l.retainSegments()
+	// Offloaded segments already left the log, their objects are deleted
+	// without holding the lock since the object store can take a while.
+	for i, rs := range remote {
+		if derr := deleteRemote(l.Config.Tiering.Store, rs); derr != nil {
+			return derr
+		}
+		l.recordDeleted(rs.BaseOffset, rs.NextOffset, rs.Size, reasons[i])
+	}
+	return err
+}
+
+// retainSegments removes the segments that fall outside the retention limits
+// from the log and deletes the local ones. It returns the offloaded ones that
+// were removed, whose objects must still be deleted, and why.
+func (l *Log) retainSegments() ([]remoteSegment, []string, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	maxBytes, maxAge := l.Config.Retention.MaxBytes, l.Config.Retention.MaxAge
+	var total uint64
+	for _, rs := range l.remote {
+		total += rs.Size
+	}
+	for _, s := range l.segments {
+		total += s.storeSize()
+	}
+	deadline := time.Now().Add(-maxAge).UnixNano()
+	// outside returns why a segment whose last record was appended at the given time must be deleted, if it must.
+	outside := func(lastAppendTime int64) string {
+		if maxBytes > 0 && total > maxBytes {
+			return "size"
+		}
+		if maxAge > 0 && lastAppendTime < deadline {
+			return "age"
+		}
+		return ""
+	}
+
+	// Offloaded segments come first.
+	var remote []remoteSegment
+	var remoteReasons []string
+	for len(l.remote) > 0 {
+		rs := l.remote[0]
+		reason := outside(rs.LastAppendTime)
+		if reason == "" {
+			break
+		}
+		l.remote = l.remote[1:]
+		total -= rs.Size
+		remote = append(remote, rs)
+		remoteReasons = append(remoteReasons, reason)
+	}
+
+	// Segments are only deleted from the start of the log so it stays contiguous.
+	segments := l.segments
+	var removed []*segment
+	var reasons []string
+	for len(segments) > 1 {
+		s := segments[0]
+		reason := outside(s.lastAppendTime())
+		if reason == "" {
+			break
+		}
+		segments = segments[1:]
+		total -= s.storeSize()
+		removed = append(removed, s)
+		reasons = append(reasons, reason)
+	}
+	l.segments = segments
+	// Reads in progress keep the deleted segments open until they're done.
+	l.publish()
+
+	for i, s := range removed {
+		size := s.storeSize()
+		if err := s.Remove(); err != nil {
+			return remote, remoteReasons, err
+		}
+		l.recordDeleted(s.baseOffset, s.nextOffset, size, reasons[i])
+	}
+	return remote, remoteReasons, nil
+}
+
+// recordDeleted reports the deletion of a segment by the retention policy.
+func (l *Log) recordDeleted(baseOffset, nextOffset, size uint64, reason string) {
+	_ = stats.RecordWithTags(
+		context.Background(),
+		[]tag.Mutator{tag.Upsert(reasonKey, reason)},
+		segmentsDeleted.M(1),
+		bytesDeleted.M(int64(size)),
+	)
+	l.logger.Info(
+		"deleted segment outside the retention limits",
+		zap.Uint64("segment", baseOffset),
+		zap.Uint64("next_offset", nextOffset),
+		zap.Uint64("bytes", size),
+		zap.String("reason", reason),
+	)
+}
+
+// retentionLoop enforces the retention limits on every configured interval until the log is closed.
+func (l *Log) retentionLoop() {
+	defer l.wg.Done()
+
+	interval := l.Config.Retention.Interval
+	if interval == 0 {
+		interval = defaultRetentionInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.done:
+			return
+		case <-ticker.C:
+			if err := l.retain(); err != nil {
+				l.logger.Error("retention failed", zap.Error(err))
+			}
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package log
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	api "github.com/AYM1607/proglog/api/v1"
+	"github.com/stretchr/testify/require"
+	"go.opencensus.io/stats/view"
+)
+
+func TestRetention(t *testing.T) {
+	for scenario, fn := range map[string]func(
+		t *testing.T, log *Log,
+	){
+		"delete by size":          testRetainSize,
+		"delete by age":           testRetainAge,
+		"keep the active segment": testRetainActive,
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "retention-test")
+			require.NoError(t, err)
+			defer os.RemoveAll(dir)
+
+			c := Config{}
+			// This ensures that each segment can only hold one record.
+			c.Segment.MaxIndexBytes = entWidth
+			log, err := NewLog(dir, c)
+			require.NoError(t, err)
+			defer log.Close()
+
+			fn(t, log)
+		})
+	}
+}
+
+func appendRecords(t *testing.T, log *Log, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		_, err := log.Append(&api.Record{Value: []byte("hello world")})
+		require.NoError(t, err)
+	}
+}
+
+func testRetainSize(t *testing.T, log *Log) {
+	appendRecords(t, log, 4)
+	width := log.segments[0].store.size
+
+	before := deletedSegments(t, "size")
+	// Leave room for two full segments and part of a third one.
+	log.Config.Retention.MaxBytes = width*2 + width/2
+	require.NoError(t, log.retain())
+
+	off, err := log.LowestOffset()
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), off, "The oldest segments should be deleted until the log fits.")
+	_, err = log.Read(1)
+	require.Error(t, err)
+	read, err := log.Read(2)
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), read.Offset)
+	require.Equal(t, before+2, deletedSegments(t, "size"), "Deletions should be reported.")
+}
+
+func testRetainAge(t *testing.T, log *Log) {
+	appendRecords(t, log, 2)
+	time.Sleep(100 * time.Millisecond)
+	appendRecords(t, log, 1)
+
+	log.Config.Retention.MaxAge = 50 * time.Millisecond
+	require.NoError(t, log.retain())
+
+	off, err := log.LowestOffset()
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), off, "Only segments with old records should be deleted.")
+}
+
+func testRetainActive(t *testing.T, log *Log) {
+	appendRecords(t, log, 2)
+
+	log.Config.Retention.MaxBytes = 1
+	log.Config.Retention.MaxAge = time.Nanosecond
+	require.NoError(t, log.retain())
+
+	require.Len(t, log.segments, 1, "The active segment should never be deleted.")
+	require.Equal(t, log.activeSegment, log.segments[0])
+	off, err := log.Append(&api.Record{Value: []byte("hello world")})
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), off)
+}
+
+func TestRetentionInBackground(t *testing.T) {
+	dir, err := ioutil.TempDir("", "retention-background-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := Config{}
+	c.Segment.MaxIndexBytes = entWidth
+	c.Retention.MaxBytes = 1
+	c.Retention.Interval = time.Millisecond
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+
+	appendRecords(t, log, 3)
+	require.Eventually(t, func() bool {
+		off, err := log.LowestOffset()
+		return err == nil && off == 3
+	}, time.Second, time.Millisecond, "Segments should be deleted in the background.")
+
+	// Closing the log stops the retention goroutine.
+	require.NoError(t, log.Close())
+}
+
+// deletedSegments returns the number of segments the retention policy deleted for the given reason.
+func deletedSegments(t *testing.T, reason string) int64 {
+	t.Helper()
+	rows, err := view.RetrieveData(segmentsDeleted.Name())
+	require.NoError(t, err)
+	for _, row := range rows {
+		for _, tag := range row.Tags {
+			if tag.Key == reasonKey && tag.Value == reason {
+				return int64(row.Data.(*view.SumData).Value)
+			}
+		}
+	}
+	return 0
+}
@@ -0,0 +1,88 @@
+package log
+
+import (
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// rollLoop rolls the active segment once it gets older than the configured max
+// age, even if there aren't any appends, until the log is closed.
+func (l *Log) rollLoop() {
+	defer l.wg.Done()
+
+	timer := time.NewTimer(l.rollDelay())
+	defer timer.Stop()
+	for {
+		select {
+		case <-l.done:
+			return
+		case <-timer.C:
+			if err := l.rollAged(); err != nil {
+				l.logger.Error("segment roll failed", zap.Error(err))
+				// Don't retry right away.
+				timer.Reset(l.Config.Segment.MaxSegmentAge)
+				continue
+			}
+			timer.Reset(l.rollDelay())
+		}
+	}
+}
+
+// rollDelay returns how long until the active segment has to be rolled.
+func (l *Log) rollDelay() time.Duration {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.activeSegment.rollDelay()
+}
+
+// rollAged rolls the active segment if it's older than the max age.
+func (l *Log) rollAged() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.activeSegment.rollDelay() > 0 {
+		return nil
+	}
+	return l.roll(l.activeSegment.nextOffset)
+}
+
+// seal reopens the segment at position i, which isn't the active one anymore,
+// read only. It must be called while holding the write lock.
+func (l *Log) seal(i int) error {
+	s := l.segments[i]
+	sealed, err := l.reopenSealed(s)
+	if err != nil {
+		return err
+	}
+	l.segments[i] = sealed
+	// Reads in progress keep the writable segment open until they're done.
+	l.publish()
+	return s.Close()
+}
+
+// reopenSealed opens s again read only, with its store mapped to memory. s is
+// left open, it's closed once the reopened segment takes its place.
+func (l *Log) reopenSealed(s *segment) (*segment, error) {
+	// Writable indexes are grown to their max size, read only ones are
+	// mapped at the size of their file.
+	for _, idx := range []*index{s.index, s.timeIndex} {
+		if err := idx.shrink(); err != nil {
+			return nil, err
+		}
+	}
+	sealed, err := newSegment(l.Dir, s.baseOffset, l.sealedConfig())
+	if err != nil {
+		return nil, err
+	}
+	// Newly sealed segments count as used so the latest ones are kept open.
+	sealed.touch()
+	return sealed, nil
+}
+
+// sealedConfig returns the config sealed segments are opened with.
+func (l *Log) sealedConfig() Config {
+	c := l.Config
+	c.ReadOnly = true
+	return c
+}
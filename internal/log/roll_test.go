@@ -0,0 +1,109 @@
+package log
+
+import (
+	"bufio"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRollByAge(t *testing.T) {
+	dir, err := ioutil.TempDir("", "roll-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := Config{}
+	c.Segment.MaxSegmentAge = time.Hour
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+	appendRecords(t, log, 1)
+	require.False(t, log.activeSegment.IsMaxed())
+	require.NoError(t, log.Close())
+
+	// The age of a segment comes from its first record so it survives restarts.
+	time.Sleep(10 * time.Millisecond)
+	c.Segment.MaxSegmentAge = 100 * time.Millisecond
+	log, err = NewLog(dir, c)
+	require.NoError(t, err)
+	// Leave the background roll out of it, before it's due, to check the one on append.
+	log.stop()
+	delay := log.activeSegment.rollDelay()
+	require.Less(t, int64(delay), int64(90*time.Millisecond))
+	require.False(t, log.activeSegment.IsMaxed())
+
+	time.Sleep(delay)
+	require.True(t, log.activeSegment.IsMaxed(), "The active segment should be too old.")
+	appendRecords(t, log, 1)
+	require.Len(t, log.segments, 2, "Appending should roll the old segment first.")
+	require.Equal(t, uint64(1), log.activeSegment.baseOffset)
+	require.NoError(t, log.Close())
+}
+
+func TestRollByAgeInBackground(t *testing.T) {
+	dir, err := ioutil.TempDir("", "roll-background-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := Config{}
+	c.Segment.MaxSegmentAge = 10 * time.Millisecond
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+
+	appendRecords(t, log, 1)
+	segments := func() int {
+		log.mu.RLock()
+		defer log.mu.RUnlock()
+		return len(log.segments)
+	}
+	require.Eventually(t, func() bool {
+		return segments() == 2
+	}, time.Second, time.Millisecond, "The active segment should be rolled without appends.")
+
+	// Empty segments don't age.
+	time.Sleep(30 * time.Millisecond)
+	require.Equal(t, 2, segments())
+
+	// Closing the log stops the background rolls.
+	require.NoError(t, log.Close())
+}
+
+func TestRollUnmapsIndexes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "roll-unmap-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := Config{}
+	// This ensures that each segment can only hold one record.
+	c.Segment.MaxIndexBytes = entWidth
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+	defer log.Close()
+	appendRecords(t, log, 20)
+
+	// Sealed segments map their store and both indexes, the active segment
+	// only its indexes. The writable copies of the sealed ones are gone.
+	require.Equal(t, 3*20+2, mappings(t, dir))
+}
+
+// mappings returns the number of memory mappings of the files in dir.
+func mappings(t *testing.T, dir string) int {
+	t.Helper()
+	f, err := os.Open("/proc/self/maps")
+	if err != nil {
+		t.Skip("memory mappings can't be listed: ", err)
+	}
+	defer f.Close()
+	n := 0
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if strings.Contains(scanner.Text(), dir+"/") {
+			n++
+		}
+	}
+	require.NoError(t, scanner.Err())
+	return n
+}
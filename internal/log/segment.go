@@ -1,47 +1,80 @@
 package log
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path"
+	"sort"
+	"sync/atomic"
+	"time"
 
 	api "github.com/AYM1607/proglog/api/v1"
 	"google.golang.org/protobuf/proto"
 )
 
+// errRetired is returned when reading a segment that already left the log.
+var errRetired = errors.New("segment was retired")
+
 type segment struct {
-	store                  *store
-	index                  *index
+	store *store
+	index *index
+	// timeIndex maps the append time of every record to its offset. It reuses
+	// the index format with the time, in Unix nanoseconds, in place of the position.
+	timeIndex              *index
 	baseOffset, nextOffset uint64
 	config                 Config
+	dir                    string
+	// refs counts the users of the segment: whoever opened it, usually the
+	// log, and every read in progress. The files are closed once it's zero.
+	refs int32
+	// used is when the segment was last read, in Unix nanoseconds.
+	used int64
+
+	// closed is set on the sealed segments that take the place of the ones
+	// whose files were closed, to stay within the max number of open segments.
+	// They don't have any files, only the stats below, and are opened again
+	// when they're read.
+	closed              bool
+	size                uint64
+	firstTime, lastTime int64
 }
 
 func newSegment(dir string, baseOffset uint64, c Config) (*segment, error) {
 	s := &segment{
 		baseOffset: baseOffset,
 		config:     c,
+		dir:        dir,
+		refs:       1,
 	}
 
 	// This code comes from the book, why declare the error as a variable?
 	var err error
 
+	// Read only segments must already exist.
+	storeFlag, indexFlag := os.O_RDWR|os.O_CREATE|os.O_APPEND, os.O_RDWR|os.O_CREATE
+	if c.ReadOnly {
+		storeFlag, indexFlag = os.O_RDONLY, os.O_RDONLY
+	}
+
 	// Store creation.
 	storeFile, err := os.OpenFile(
-		path.Join(dir, fmt.Sprintf("%d%s", baseOffset, ".store")),
-		os.O_RDWR|os.O_CREATE|os.O_APPEND,
+		s.path(".store"),
+		storeFlag,
 		0644,
 	)
 	if err != nil {
 		return nil, err
 	}
-	if s.store, err = newStore(storeFile); err != nil {
+	if s.store, err = newStore(storeFile, c); err != nil {
 		return nil, err
 	}
 
 	// Index creation.
 	indexFile, err := os.OpenFile(
-		path.Join(dir, fmt.Sprintf("%d%s", baseOffset, ".index")),
-		os.O_RDWR|os.O_CREATE,
+		s.path(".index"),
+		indexFlag,
 		0644,
 	)
 	if err != nil {
@@ -51,88 +84,495 @@ func newSegment(dir string, baseOffset uint64, c Config) (*segment, error) {
 		return nil, err
 	}
 
+	// Time index creation.
+	timeIndexFile, err := os.OpenFile(
+		s.path(".timeindex"),
+		indexFlag,
+		0644,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if s.timeIndex, err = newIndex(timeIndexFile, c); err != nil {
+		return nil, err
+	}
+
+	s.setNextOffset()
+
+	return s, nil
+}
+
+// path returns the path of the segment's file with the given extension.
+func (s *segment) path(ext string) string {
+	return path.Join(s.dir, fmt.Sprintf("%d%s", s.baseOffset, ext))
+}
+
+// closedCopy returns a segment without files that describes the sealed segment
+// s, to take its place once its files are closed.
+func (s *segment) closedCopy() *segment {
+	return &segment{
+		baseOffset: s.baseOffset,
+		nextOffset: s.nextOffset,
+		config:     s.config,
+		dir:        s.dir,
+		refs:       1,
+		used:       s.lastUsed(),
+		closed:     true,
+		size:       s.store.size,
+		firstTime:  s.firstAppendTime(),
+		lastTime:   s.lastAppendTime(),
+	}
+}
+
+// touch marks the segment as used now.
+func (s *segment) touch() {
+	atomic.StoreInt64(&s.used, time.Now().UnixNano())
+}
+
+// lastUsed returns when the segment was last read, in Unix nanoseconds.
+func (s *segment) lastUsed() int64 {
+	return atomic.LoadInt64(&s.used)
+}
+
+// storeSize returns the size of the segment's store in bytes.
+func (s *segment) storeSize() uint64 {
+	if s.closed {
+		return s.size
+	}
+	return s.store.size
+}
+
+// setNextOffset derives the offset of the next record from the last entry of the index.
+func (s *segment) setNextOffset() {
 	// If Read(-1) returns an error it means that the index's underlying file
 	// and thus itself is empty.
 	if off, _, err := s.index.Read(-1); err != nil {
-		s.nextOffset = baseOffset
+		atomic.StoreUint64(&s.nextOffset, s.baseOffset)
 	} else {
 		// Add one because the relative offsets from the index start at 0.
-		s.nextOffset = baseOffset + uint64(off) + 1
+		atomic.StoreUint64(&s.nextOffset, s.baseOffset+uint64(off)+1)
 	}
+}
 
-	return s, nil
+// loadNextOffset returns the offset of the next record, it can be called while the segment is appended to.
+func (s *segment) loadNextOffset() uint64 {
+	return atomic.LoadUint64(&s.nextOffset)
 }
 
 func (s *segment) Append(record *api.Record) (offset uint64, err error) {
-	cur := s.nextOffset
-	record.Offset = cur
+	record.Offset = s.nextOffset
+	// Append times never go back within a segment so the time index can be searched.
+	record.AppendTime = time.Now().UnixNano()
+	if last := s.lastAppendTime(); last > record.AppendTime {
+		record.AppendTime = last
+	}
+
+	if err = s.write(record); err != nil {
+		return 0, err
+	}
+	return record.Offset, nil
+}
+
+// write appends the record keeping its offset and append time, which must not
+// be lower than the ones of the last record in the segment.
+func (s *segment) write(record *api.Record) error {
+	// Don't write to the store if the record can't be indexed.
+	if s.index.isMaxed() {
+		return io.EOF
+	}
 
 	p, err := proto.Marshal(record)
 	if err != nil {
-		return 0, err
+		return err
+	}
+	if p, err = encode(s.config.Segment.Codec, p); err != nil {
+		return err
 	}
 
 	_, pos, err := s.store.Append(p)
 	if err != nil {
-		return 0, err
+		return err
 	}
 
-	if err = s.index.Write(
-		// Index offsets are relative to baseOffset.
-		uint32(s.nextOffset-uint64(s.baseOffset)),
-		pos,
-	); err != nil {
-		return 0, err
+	// Index offsets are relative to baseOffset.
+	rel := record.Offset - s.baseOffset
+	if err = s.index.Write(rel, pos); err != nil {
+		return err
+	}
+	if err = s.timeIndex.Write(rel, uint64(record.AppendTime)); err != nil {
+		return err
+	}
+	// Reads that don't hold the log's lock only look at the records before nextOffset.
+	atomic.StoreUint64(&s.nextOffset, record.Offset+1)
+	return nil
+}
+
+// OffsetForTime returns the offset of the first record appended at or after t.
+// The returned bool is false if every record in the segment was appended before t.
+func (s *segment) OffsetForTime(t time.Time) (uint64, bool) {
+	n := int(s.timeIndex.size / entWidth)
+	i := sort.Search(n, func(i int) bool {
+		_, ts, _ := s.timeIndex.Read(int64(i))
+		return int64(ts) >= t.UnixNano()
+	})
+	if i == n {
+		return 0, false
+	}
+	off, _, _ := s.timeIndex.Read(int64(i))
+	return s.baseOffset + uint64(off), true
+}
+
+// firstAppendTime returns the append time of the oldest record in Unix nanoseconds, 0 if the segment is empty.
+func (s *segment) firstAppendTime() int64 {
+	if s.closed {
+		return s.firstTime
+	}
+	_, ts, err := s.timeIndex.Read(0)
+	if err != nil {
+		return 0
+	}
+	return int64(ts)
+}
+
+// lastAppendTime returns the append time of the newest record in Unix nanoseconds, 0 if the segment is empty.
+func (s *segment) lastAppendTime() int64 {
+	if s.closed {
+		return s.lastTime
+	}
+	_, ts, err := s.timeIndex.Read(-1)
+	if err != nil {
+		return 0
 	}
-	s.nextOffset += 1
-	return cur, nil
+	return int64(ts)
 }
 
+// Read returns the first record at or after off. Offsets can be missing from a
+// segment once it's compacted, so the returned record's offset may be greater.
+// io.EOF is returned if there isn't any record at or after off.
 func (s *segment) Read(off uint64) (*api.Record, error) {
-	_, pos, err := s.index.Read(int64(off - s.baseOffset))
+	n, ok := s.entry(off)
+	if !ok {
+		return nil, io.EOF
+	}
+	rel, pos, err := s.index.Read(n)
 	if err != nil {
 		return nil, err
 	}
 
 	p, err := s.store.Read(pos)
+	if err == errCorrupt {
+		return nil, api.ErrCorruptRecord{
+			Segment: s.baseOffset,
+			Offset:  s.baseOffset + rel,
+		}
+	}
 	if err != nil {
 		return nil, err
 	}
 
+	return unmarshalRecord(p)
+}
+
+// entry returns the number of the first index entry whose offset is at or after off.
+func (s *segment) entry(off uint64) (int64, bool) {
+	if off >= s.loadNextOffset() {
+		return 0, false
+	}
+	if off < s.baseOffset {
+		off = s.baseOffset
+	}
+	n := s.index.entries()
+	rel := off - s.baseOffset
+	// Unless the segment was compacted, the entry number is the relative offset.
+	if rel < n {
+		if got, _, _ := s.index.Read(int64(rel)); got == rel {
+			return int64(rel), true
+		}
+	}
+	i := sort.Search(int(n), func(i int) bool {
+		got, _, _ := s.index.Read(int64(i))
+		return got >= rel
+	})
+	return int64(i), true
+}
+
+// scan calls fn with every record of the segment in order. It stops with an
+// api.ErrCorruptRecord at the first record that fails its integrity check.
+func (s *segment) scan(fn func(*api.Record) error) error {
+	for n := int64(0); uint64(n) < s.index.size/entWidth; n++ {
+		rel, pos, err := s.index.Read(n)
+		if err != nil {
+			return err
+		}
+		p, err := s.store.Read(pos)
+		if err == errCorrupt {
+			return api.ErrCorruptRecord{
+				Segment: s.baseOffset,
+				Offset:  s.baseOffset + rel,
+			}
+		}
+		if err != nil {
+			return err
+		}
+		record, err := unmarshalRecord(p)
+		if err != nil {
+			return err
+		}
+		if err = fn(record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// unmarshalRecord decodes a record as it was appended to the store.
+func unmarshalRecord(p []byte) (*api.Record, error) {
+	p, err := decode(p)
+	if err != nil {
+		return nil, err
+	}
 	record := &api.Record{}
 	err = proto.Unmarshal(p, record)
 	return record, err
 }
 
+// indexMatchesStore reports whether the index entries describe the records of the store.
+func (s *segment) indexMatchesStore(full bool) (bool, error) {
+	entries := s.index.size / entWidth
+	// The time index must have an entry for every record in the index.
+	if s.timeIndex.size != s.index.size {
+		return false, nil
+	}
+	if entries > 0 {
+		off, _, _ := s.index.Read(-1)
+		if timeOff, _, _ := s.timeIndex.Read(-1); timeOff != off {
+			return false, nil
+		}
+	}
+	if entries == 0 {
+		return s.store.size == s.store.first, nil
+	}
+
+	first := entries - 1
+	if full {
+		first = 0
+	}
+	var prev uint64
+	pos := s.store.first
+	for n := first; n < entries; n++ {
+		off, p, err := s.index.Read(int64(n))
+		if err != nil {
+			return false, err
+		}
+		// Entries must have increasing offsets and point right after the previous record.
+		if full && ((n > 0 && off <= prev) || p != pos) {
+			return false, nil
+		}
+		prev = off
+		b, err := s.store.Read(p)
+		if err == errCorrupt && !full {
+			// The data of a record in a sealed segment went bad, its index still
+			// matches as long as the record is framed right.
+			width, ok, err := s.store.recordWidth(p)
+			if err != nil || !ok {
+				return false, err
+			}
+			pos = p + width
+			continue
+		}
+		if err == errCorrupt {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		pos = p + s.store.frameWidth() + uint64(len(b))
+	}
+	// The last record must end the store.
+	return pos == s.store.size, nil
+}
+
+// RebuildIndex discards the index and the time index and rebuilds them by scanning the records of the store.
+// The store is truncated at its first incomplete or corrupt record, only the
+// active segment can have a torn tail.
+// Returns the number of index entries and store bytes that were dropped.
+func (s *segment) RebuildIndex() (records, bytes uint64, err error) {
+	records, bytes, _, err = s.rebuildIndex(false)
+	return records, bytes, err
+}
+
+// RebuildSealedIndex rebuilds the indexes of a sealed segment like RebuildIndex
+// but never changes its store. Corrupt records are kept in the index, at the
+// offset after the previous record, so reading them fails. An error is
+// returned if a record runs past the end of the store. Returns the number of
+// corrupt records.
+func (s *segment) RebuildSealedIndex() (corrupt uint64, err error) {
+	_, _, corrupt, err = s.rebuildIndex(true)
+	return corrupt, err
+}
+
+func (s *segment) rebuildIndex(sealed bool) (records, bytes, corrupt uint64, err error) {
+	// The index is grown to its max size when opened, entries past the ones
+	// that were actually written are zeroed and don't count as records.
+	var entries uint64
+	for i := uint64(0); i < s.index.size/entWidth; i++ {
+		if _, p, _ := s.index.Read(int64(i)); i == 0 || p != 0 {
+			entries++
+		}
+	}
+	size := s.store.size
+
+	if err = s.index.Truncate(0); err != nil {
+		return 0, 0, 0, err
+	}
+	if err = s.timeIndex.Truncate(0); err != nil {
+		return 0, 0, 0, err
+	}
+
+	pos := s.store.first
+	var last int64
+	next := s.baseOffset
+	for pos < s.store.size {
+		p, err := s.store.Read(pos)
+		if err != nil && err != errCorrupt {
+			return 0, 0, 0, err
+		}
+		var record *api.Record
+		if err == nil {
+			record, err = unmarshalRecord(p)
+		}
+		width := s.store.frameWidth() + uint64(len(p))
+		if err != nil || record.Offset < next {
+			if !sealed {
+				break
+			}
+			var ok bool
+			if width, ok, err = s.store.recordWidth(pos); err != nil {
+				return 0, 0, 0, err
+			}
+			if !ok {
+				return 0, 0, 0, fmt.Errorf("record at position %d of sealed segment %d runs past the end of its store", pos, s.baseOffset)
+			}
+			record = &api.Record{Offset: next}
+			corrupt++
+		}
+		rel := record.Offset - s.baseOffset
+		if err = s.index.Write(rel, pos); err != nil {
+			return 0, 0, 0, err
+		}
+		// Records written before append times were tracked don't have one.
+		if record.AppendTime > last {
+			last = record.AppendTime
+		}
+		if err = s.timeIndex.Write(rel, uint64(last)); err != nil {
+			return 0, 0, 0, err
+		}
+		next = record.Offset + 1
+		pos += width
+	}
+
+	if !sealed {
+		if err = s.store.Truncate(pos); err != nil {
+			return 0, 0, 0, err
+		}
+	}
+	s.setNextOffset()
+
+	if n := s.index.size / entWidth; entries > n {
+		records = entries - n
+	}
+	return records, size - pos, corrupt, nil
+}
+
+// Sync commits the records of the segment and its indexes to disk.
+func (s *segment) Sync() error {
+	if err := s.store.Sync(); err != nil {
+		return err
+	}
+	if err := s.index.Sync(); err != nil {
+		return err
+	}
+	return s.timeIndex.Sync()
+}
+
 func (s *segment) IsMaxed() bool {
 	return s.store.size >= s.config.Segment.MaxStoreBytes ||
-		s.index.size >= s.config.Segment.MaxIndexBytes
+		s.index.size >= s.config.Segment.MaxIndexBytes ||
+		(s.config.Segment.MaxSegmentAge > 0 && s.rollDelay() <= 0)
 }
 
+// rollDelay returns how long until the segment gets older than its max age.
+// Empty segments don't age, the max age itself is returned for them.
+func (s *segment) rollDelay() time.Duration {
+	maxAge := s.config.Segment.MaxSegmentAge
+	if s.index.size == 0 {
+		return maxAge
+	}
+	first := time.Unix(0, s.firstAppendTime())
+	return maxAge - time.Since(first)
+}
+
+// acquire takes a reference to the segment so it isn't closed while it's read.
+// It returns false if the segment was already closed.
+func (s *segment) acquire() bool {
+	for {
+		refs := atomic.LoadInt32(&s.refs)
+		if refs <= 0 {
+			return false
+		}
+		if atomic.CompareAndSwapInt32(&s.refs, refs, refs+1) {
+			return true
+		}
+	}
+}
+
+// release drops a reference to the segment and closes it if it was the last one.
+func (s *segment) release() error {
+	if atomic.AddInt32(&s.refs, -1) != 0 {
+		return nil
+	}
+	return s.close()
+}
+
+// Close drops the reference taken when the segment was opened. The files are
+// closed right away unless the segment is being read, in which case the last
+// read closes them.
 func (s *segment) Close() error {
+	return s.release()
+}
+
+func (s *segment) close() error {
+	if s.closed {
+		return nil
+	}
 	if err := s.index.Close(); err != nil {
 		return err
 	}
+	if err := s.timeIndex.Close(); err != nil {
+		return err
+	}
 	if err := s.store.Close(); err != nil {
 		return err
 	}
 	return nil
 }
 
+// Remove deletes the files of the segment and closes it. Reads in progress
+// keep reading the open files until they're done.
 func (s *segment) Remove() error {
-	if err := s.Close(); err != nil {
+	if err := os.Remove(s.path(".index")); err != nil {
 		return err
 	}
 
-	if err := os.Remove(s.index.Name()); err != nil {
+	if err := os.Remove(s.path(".timeindex")); err != nil {
 		return err
 	}
 
-	if err := os.Remove(s.store.Name()); err != nil {
+	if err := os.Remove(s.path(".store")); err != nil {
 		return err
 	}
 
-	return nil
+	return s.Close()
 }
 
 // nearestMultiple returns the nearest and lesser multiple of k in j.
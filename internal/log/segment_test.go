@@ -5,9 +5,11 @@ import (
 	"io/ioutil"
 	"os"
 	"testing"
+	"time"
 
 	api "github.com/AYM1607/proglog/api/v1"
 	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
 )
 
 func TestSegment(t *testing.T) {
@@ -24,6 +26,8 @@ func TestSegment(t *testing.T) {
 	require.NoError(t, err, "The segment is created successfully")
 	require.Equal(t, uint64(16), s.nextOffset, "Next offset should be equal to base offset for new segments.")
 
+	start := time.Now()
+	var appendTimes []time.Time
 	for i := uint64(0); i < 3; i++ {
 		off, err := s.Append(want)
 		require.NoError(t, err, "Record should be appended successfully.")
@@ -32,8 +36,20 @@ func TestSegment(t *testing.T) {
 		got, err := s.Read(off)
 		require.NoError(t, err, "Existing records should be read successfully.")
 		require.Equal(t, want.Value, got.Value, "Record's read data should be the same as the written one.")
+		appendTimes = append(appendTimes, time.Unix(0, got.AppendTime))
 	}
 
+	off, ok := s.OffsetForTime(start)
+	require.True(t, ok)
+	require.Equal(t, uint64(16), off, "The first record was appended after the start time.")
+	for i, at := range appendTimes {
+		off, ok = s.OffsetForTime(at)
+		require.True(t, ok)
+		require.LessOrEqual(t, off, uint64(16+i), "Records appended at the same time resolve to the first one.")
+	}
+	_, ok = s.OffsetForTime(appendTimes[2].Add(time.Nanosecond))
+	require.False(t, ok, "No record was appended after the last one.")
+
 	_, err = s.Append(want)
 	require.Equal(t, io.EOF, err, "Appends should fail if the segment is maxed.")
 
@@ -58,3 +74,137 @@ func TestSegment(t *testing.T) {
 	require.False(t, s.IsMaxed(), "Segment should not be maxed if new and config's limits are non-zero.")
 
 }
+
+func TestSegmentRebuildIndex(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "segment-rebuild-index-test")
+	defer os.RemoveAll(dir)
+
+	want := &api.Record{Value: []byte("hello world")}
+
+	c := Config{}
+	c.Segment.MaxIndexBytes = entWidth * 10
+	c.Segment.MaxStoreBytes = 1024
+
+	s, err := newSegment(dir, 16, c)
+	require.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		_, err = s.Append(want)
+		require.NoError(t, err)
+	}
+
+	// Simulate a crash in the middle of the last write: the segment is never
+	// closed, so the index keeps its max size, and the store is missing a few bytes.
+	require.NoError(t, s.store.buf.Flush())
+	recordWidth := (s.store.size - storeHeaderWidth) / 3
+	require.NoError(t, os.Truncate(s.store.Name(), int64(s.store.size-3)))
+
+	s, err = newSegment(dir, 16, c)
+	require.NoError(t, err)
+	ok, err := s.indexMatchesStore(true)
+	require.NoError(t, err)
+	require.False(t, ok, "The index should not match a store with a torn record.")
+	records, bytes, err := s.RebuildIndex()
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), records, "Only the torn record should be dropped.")
+	require.Equal(t, recordWidth-3, bytes, "The partial record should be dropped from the store.")
+	require.Equal(t, storeHeaderWidth+2*recordWidth, s.store.size, "The store should end at the last complete record.")
+	require.Equal(t, uint64(18), s.nextOffset)
+
+	for off := uint64(16); off < 18; off++ {
+		got, err := s.Read(off)
+		require.NoError(t, err, "Complete records should be kept.")
+		require.Equal(t, want.Value, got.Value)
+	}
+
+	off, err := s.Append(want)
+	require.NoError(t, err, "The recovered segment should accept new records.")
+	require.Equal(t, uint64(18), off)
+	got, err := s.Read(off)
+	require.NoError(t, err)
+	require.Equal(t, want.Value, got.Value)
+
+	ok, err = s.indexMatchesStore(true)
+	require.NoError(t, err)
+	require.True(t, ok, "The rebuilt index should match its store.")
+
+	// An index can be rebuilt even if its file is gone.
+	require.NoError(t, s.Close())
+	require.NoError(t, os.Remove(s.index.Name()))
+	s, err = newSegment(dir, 16, c)
+	require.NoError(t, err)
+	ok, err = s.indexMatchesStore(false)
+	require.NoError(t, err)
+	require.False(t, ok, "A missing index should not match a non empty store.")
+	records, bytes, err = s.RebuildIndex()
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), records)
+	require.Equal(t, uint64(0), bytes)
+	require.Equal(t, uint64(19), s.nextOffset)
+	for off := uint64(16); off < 19; off++ {
+		got, err := s.Read(off)
+		require.NoError(t, err, "Every record should be readable through the rebuilt index.")
+		require.Equal(t, want.Value, got.Value)
+	}
+	require.NoError(t, s.Remove())
+}
+
+func TestSegmentRecordFields(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "segment-record-fields-test")
+	defer os.RemoveAll(dir)
+
+	c := Config{}
+	c.Segment.MaxIndexBytes = 1024
+	c.Segment.MaxStoreBytes = 1024
+
+	s, err := newSegment(dir, 0, c)
+	require.NoError(t, err)
+	defer s.Remove()
+
+	want := &api.Record{
+		Value: []byte("hello world"),
+		Key:   []byte("greeting"),
+		Headers: []*api.Header{
+			{Key: "content-type", Value: []byte("text/plain")},
+			{Key: "trace-id", Value: []byte("42")},
+		},
+		Timestamp: time.Date(2021, 7, 1, 9, 0, 0, 0, time.UTC).UnixNano(),
+	}
+	off, err := s.Append(want)
+	require.NoError(t, err)
+
+	got, err := s.Read(off)
+	require.NoError(t, err)
+	require.Equal(t, want.Value, got.Value)
+	require.Equal(t, want.Key, got.Key)
+	require.Equal(t, want.Timestamp, got.Timestamp, "The producer's timestamp should be kept.")
+	require.Len(t, got.Headers, 2)
+	for i, h := range want.Headers {
+		require.Equal(t, h.Key, got.Headers[i].Key)
+		require.Equal(t, h.Value, got.Headers[i].Value)
+	}
+
+	// Records written before keys and headers existed only have a value and an
+	// offset, and were framed by their length alone in stores without a header.
+	var legacy []byte
+	legacy = protowire.AppendTag(legacy, 1, protowire.BytesType)
+	legacy = protowire.AppendBytes(legacy, []byte("legacy"))
+	legacy = protowire.AppendTag(legacy, 2, protowire.VarintType)
+	legacy = protowire.AppendVarint(legacy, 100)
+	writeLegacySegment(t, dir, 100, [][]byte{legacy})
+
+	old, err := newSegment(dir, 100, c)
+	require.NoError(t, err)
+	defer old.Remove()
+	require.True(t, old.store.legacy)
+	// Stores in the old format didn't have a time index.
+	_, _, err = old.RebuildIndex()
+	require.NoError(t, err)
+	require.Equal(t, uint64(101), old.nextOffset)
+
+	got, err = old.Read(100)
+	require.NoError(t, err, "Records in the old format should still be readable.")
+	require.Equal(t, []byte("legacy"), got.Value)
+	require.Equal(t, uint64(100), got.Offset)
+	require.Nil(t, got.Key)
+	require.Empty(t, got.Headers)
+}
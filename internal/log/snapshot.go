@@ -0,0 +1,334 @@
+package log
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+const (
+	// snapshotVersion is the version of the archive written by Snapshot.
+	snapshotVersion = 1
+	// manifestName is the name of the archive entry that describes the snapshot.
+	manifestName = "manifest.json"
+	// restoreDir is the directory, inside the log's, where snapshots are extracted during a restore.
+	restoreDir = ".restore"
+)
+
+// manifest describes the segments of a snapshot and the config they were written with.
+type manifest struct {
+	Version int `json:"version"`
+	Segment struct {
+		MaxStoreBytes uint64 `json:"max_store_bytes"`
+		MaxIndexBytes uint64 `json:"max_index_bytes"`
+		InitialOffset uint64 `json:"initial_offset"`
+		// Codec is the id of the codec, 0 if records weren't compressed.
+		Codec byte `json:"codec"`
+	} `json:"segment"`
+	Segments []manifestSegment `json:"segments"`
+}
+
+type manifestSegment struct {
+	BaseOffset uint64 `json:"base_offset"`
+	NextOffset uint64 `json:"next_offset"`
+	// Size is the size of the segment's store in bytes.
+	Size uint64 `json:"size"`
+}
+
+// Snapshot writes a tar archive with the records of the log to w, one entry
+// for the store of each segment followed by a manifest that describes them.
+// Indexes aren't archived, Restore rebuilds them from the stores.
+//
+// The log can be used while the snapshot is taken. It holds the records that
+// were appended when Snapshot was called except for the segments that were
+// removed while it ran, which are left out of the manifest. Segments offloaded
+// to the object store are copied from it, they're local once restored.
+func (l *Log) Snapshot(w io.Writer) error {
+	// Compacted segments are replaced and sealed ones offloaded, keep them in
+	// place until it's done.
+	l.compactMu.Lock()
+	defer l.compactMu.Unlock()
+
+	l.mu.RLock()
+	remote := make([]remoteSegment, len(l.remote))
+	copy(remote, l.remote)
+	segments := make([]*segment, len(l.segments))
+	copy(segments, l.segments)
+	active := l.activeSegment
+	next, size := active.nextOffset, active.store.size
+	l.mu.RUnlock()
+
+	m := manifest{Version: snapshotVersion}
+	m.Segment.MaxStoreBytes = l.Config.Segment.MaxStoreBytes
+	m.Segment.MaxIndexBytes = l.Config.Segment.MaxIndexBytes
+	m.Segment.InitialOffset = l.Config.Segment.InitialOffset
+	if c := l.Config.Segment.Codec; c != nil {
+		m.Segment.Codec = c.ID()
+	}
+
+	tw := tar.NewWriter(w)
+	for _, rs := range remote {
+		ms := manifestSegment{
+			BaseOffset: rs.BaseOffset,
+			NextOffset: rs.NextOffset,
+			Size:       rs.Size,
+		}
+		copied, err := l.snapshotRemote(tw, ms)
+		if err != nil {
+			return err
+		}
+		if copied {
+			m.Segments = append(m.Segments, ms)
+		}
+	}
+	for _, s := range segments {
+		ms := manifestSegment{
+			BaseOffset: s.baseOffset,
+			NextOffset: s.nextOffset,
+			Size:       s.storeSize(),
+		}
+		// Records appended after the snapshot started are left out.
+		if s == active {
+			ms.NextOffset, ms.Size = next, size
+		}
+
+		copied := false
+		if err := l.withSegment(s, func(s *segment) error {
+			copied = true
+			if err := tw.WriteHeader(&tar.Header{
+				Name: path.Base(s.store.Name()),
+				Mode: 0644,
+				Size: int64(ms.Size),
+			}); err != nil {
+				return err
+			}
+			_, err := io.Copy(tw, io.NewSectionReader(s.store, 0, int64(ms.Size)))
+			return err
+		}); err != nil {
+			return err
+		}
+		if copied {
+			m.Segments = append(m.Segments, ms)
+		}
+	}
+
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	if err = tw.WriteHeader(&tar.Header{
+		Name: manifestName,
+		Mode: 0644,
+		Size: int64(len(b)),
+	}); err != nil {
+		return err
+	}
+	if _, err = tw.Write(b); err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+// snapshotRemote writes the store of the offloaded segment ms to tw. It
+// returns false if the segment was removed from the object store in the
+// meantime.
+func (l *Log) snapshotRemote(tw *tar.Writer, ms manifestSegment) (bool, error) {
+	name := fmt.Sprintf("%d.store", ms.BaseOffset)
+	r, err := l.Config.Tiering.Store.Get(name)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer r.Close()
+	if err = tw.WriteHeader(&tar.Header{
+		Name: name,
+		Mode: 0644,
+		Size: int64(ms.Size),
+	}); err != nil {
+		return false, err
+	}
+	_, err = io.Copy(tw, r)
+	return true, err
+}
+
+// Restore replaces the contents of the log with the snapshot read from r.
+// The log must not have any records. The segment config is taken from the
+// snapshot so the restored log is identical to the one it was taken from, and
+// its idempotent producers are rebuilt from the records.
+// The log is left as it was if the snapshot can't be restored.
+func (l *Log) Restore(r io.Reader) error {
+	if l.Config.ReadOnly {
+		return ErrReadOnly
+	}
+	l.compactMu.Lock()
+	defer l.compactMu.Unlock()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if len(l.segments) > 1 || len(l.remote) > 0 || l.activeSegment.nextOffset != l.activeSegment.baseOffset {
+		return errors.New("can't restore a snapshot into a log with records")
+	}
+
+	dir := path.Join(l.Dir, restoreDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	m, err := extract(dir, r)
+	if err != nil {
+		return err
+	}
+
+	c := l.Config
+	c.Segment.MaxStoreBytes = m.Segment.MaxStoreBytes
+	c.Segment.MaxIndexBytes = m.Segment.MaxIndexBytes
+	c.Segment.InitialOffset = m.Segment.InitialOffset
+	if err = checkMaxIndexBytes(c.Segment.MaxIndexBytes); err != nil {
+		return err
+	}
+	c.Segment.Codec = nil
+	if m.Segment.Codec != 0 {
+		codec, ok := codecs[m.Segment.Codec]
+		if !ok {
+			return fmt.Errorf("snapshot codec id %d is not registered", m.Segment.Codec)
+		}
+		c.Segment.Codec = codec
+	}
+
+	// Build the indexes of every segment and make sure it holds the records
+	// the manifest says it does. Only the last one was active.
+	for i, ms := range m.Segments {
+		if err = restoreSegment(dir, ms, c, i < len(m.Segments)-1); err != nil {
+			return err
+		}
+	}
+
+	// Everything is in place, swap the empty log for the restored one.
+	if err = l.activeSegment.Remove(); err != nil {
+		return err
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if err = os.Rename(path.Join(dir, file.Name()), path.Join(l.Dir, file.Name())); err != nil {
+			return err
+		}
+	}
+	if err = syncDir(l.Dir); err != nil {
+		return err
+	}
+
+	// Producers registered with the empty log have to register again, the
+	// ones of the snapshot are rebuilt from its records.
+	if err = os.Remove(path.Join(l.Dir, producersFile)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	l.Config = c
+	l.segments = nil
+	l.activeSegment = nil
+	if err = l.setup(); err != nil {
+		return err
+	}
+	if err = l.replayProducers(l.segments[0].baseOffset); err != nil {
+		return err
+	}
+	return l.snapshotProducers()
+}
+
+// restoreSegment rebuilds the indexes of a segment extracted from a snapshot
+// and checks that it matches its description in the manifest. Sealed segments
+// keep their corrupt records, as they did in the log the snapshot was taken of.
+func restoreSegment(dir string, ms manifestSegment, c Config, sealed bool) error {
+	s, err := newSegment(dir, ms.BaseOffset, c)
+	if err != nil {
+		return err
+	}
+	if sealed {
+		_, err = s.RebuildSealedIndex()
+	} else {
+		_, _, err = s.RebuildIndex()
+	}
+	if err != nil {
+		s.Close()
+		return err
+	}
+	if s.store.size != ms.Size || s.nextOffset != ms.NextOffset {
+		s.Close()
+		return fmt.Errorf("snapshot segment %d is corrupt", ms.BaseOffset)
+	}
+	if err = s.Sync(); err != nil {
+		s.Close()
+		return err
+	}
+	return s.Close()
+}
+
+// extract writes the stores in the archive to dir and returns the manifest
+// once every store it lists was found.
+func extract(dir string, r io.Reader) (*manifest, error) {
+	var m *manifest
+	sizes := make(map[uint64]uint64)
+	tr := tar.NewReader(r)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if h.Name == manifestName {
+			m = &manifest{}
+			if err = json.NewDecoder(tr).Decode(m); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		// Only stores are expected, the name must not point outside the directory.
+		off, err := strconv.ParseUint(strings.TrimSuffix(h.Name, ".store"), 10, 64)
+		if err != nil || h.Name != fmt.Sprintf("%d.store", off) {
+			return nil, fmt.Errorf("unexpected snapshot entry %q", h.Name)
+		}
+		f, err := os.OpenFile(path.Join(dir, h.Name), os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+		if err != nil {
+			return nil, err
+		}
+		n, err := io.Copy(f, tr)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		if err = f.Close(); err != nil {
+			return nil, err
+		}
+		sizes[off] = uint64(n)
+	}
+
+	if m == nil {
+		return nil, errors.New("snapshot has no manifest")
+	}
+	if m.Version != snapshotVersion {
+		return nil, fmt.Errorf("unsupported snapshot version %d", m.Version)
+	}
+	for _, ms := range m.Segments {
+		if size, ok := sizes[ms.BaseOffset]; !ok || size != ms.Size {
+			return nil, fmt.Errorf("snapshot segment %d is missing or incomplete", ms.BaseOffset)
+		}
+	}
+	return m, nil
+}
@@ -0,0 +1,181 @@
+package log
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	api "github.com/AYM1607/proglog/api/v1"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnapshotRestore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "snapshot-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := Config{}
+	// This ensures that each segment can hold two records.
+	c.Segment.MaxIndexBytes = entWidth * 2
+	c.Segment.InitialOffset = 16
+	c.Segment.Codec = Gzip
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+	defer log.Close()
+
+	appendRecords(t, log, 5)
+	require.NoError(t, log.Truncate(18))
+
+	var b bytes.Buffer
+	require.NoError(t, log.Snapshot(&b))
+	// Records appended after the snapshot aren't part of it.
+	appendRecords(t, log, 1)
+
+	otherDir, err := ioutil.TempDir("", "snapshot-restore-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(otherDir)
+	other, err := NewLog(otherDir, Config{})
+	require.NoError(t, err)
+	defer other.Close()
+
+	// A broken snapshot leaves the log as it was.
+	var broken bytes.Buffer
+	require.NoError(t, tar.NewWriter(&broken).Close())
+	require.Error(t, other.Restore(&broken))
+	appendRecords(t, other, 1)
+	require.Error(t, other.Restore(bytes.NewReader(b.Bytes())), "Only empty logs can be restored.")
+
+	restoredDir, err := ioutil.TempDir("", "snapshot-restore-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(restoredDir)
+	restored, err := NewLog(restoredDir, Config{})
+	require.NoError(t, err)
+
+	require.NoError(t, restored.Restore(&b))
+	require.Equal(t, c.Segment.MaxIndexBytes, restored.Config.Segment.MaxIndexBytes)
+	require.Equal(t, Gzip, restored.Config.Segment.Codec)
+
+	off, err := restored.LowestOffset()
+	require.NoError(t, err)
+	require.Equal(t, uint64(18), off, "Truncated records are not restored.")
+	off, err = restored.HighestOffset()
+	require.NoError(t, err)
+	require.Equal(t, uint64(20), off)
+	for off := uint64(18); off <= 20; off++ {
+		want, err := log.Read(off)
+		require.NoError(t, err)
+		got, err := restored.Read(off)
+		require.NoError(t, err)
+		require.Equal(t, want.Value, got.Value)
+		require.Equal(t, want.AppendTime, got.AppendTime)
+	}
+
+	off, err = restored.Append(&api.Record{Value: []byte("hello world")})
+	require.NoError(t, err)
+	require.Equal(t, uint64(21), off)
+
+	// The restored log can be reopened.
+	require.NoError(t, restored.Close())
+	restored, err = NewLog(restoredDir, Config{})
+	require.NoError(t, err)
+	defer restored.Close()
+	_, err = restored.Read(21)
+	require.NoError(t, err)
+	_, err = restored.Read(22)
+	require.IsType(t, api.ErrOffsetOutOfRange{}, err)
+}
+
+func TestSnapshotDoesntBlockAppends(t *testing.T) {
+	dir, err := ioutil.TempDir("", "snapshot-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := Config{}
+	c.Segment.MaxIndexBytes = entWidth * 2
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+	defer log.Close()
+	appendRecords(t, log, 3)
+
+	// The snapshot is stuck copying the first segment once its first byte is
+	// read, nothing reads the rest.
+	r, w := io.Pipe()
+	snapshotted := make(chan error)
+	go func() {
+		snapshotted <- log.Snapshot(w)
+	}()
+	_, err = r.Read(make([]byte, 1))
+	require.NoError(t, err)
+
+	appended := make(chan struct{})
+	go func() {
+		defer close(appended)
+		// Enough to roll the segments.
+		for i := 0; i < 4; i++ {
+			if _, err := log.Append(&api.Record{Value: []byte("hello world")}); err != nil {
+				t.Error(err)
+			}
+		}
+	}()
+	select {
+	case <-appended:
+	case <-time.After(5 * time.Second):
+		t.Error("Appends shouldn't wait for the snapshot to be read.")
+	}
+
+	stalled := errors.New("stalled")
+	r.CloseWithError(stalled)
+	require.Equal(t, stalled, <-snapshotted)
+	<-appended
+}
+
+func TestSnapshotCorruptSegment(t *testing.T) {
+	dir, err := ioutil.TempDir("", "snapshot-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := Config{}
+	c.Segment.MaxIndexBytes = entWidth * 2
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+	appendRecords(t, log, 3)
+	s := log.segments[0]
+	_, pos, err := s.index.Read(0)
+	require.NoError(t, err)
+	require.NoError(t, log.Close())
+
+	// Damage the data of the first record of the sealed segment.
+	f, err := os.OpenFile(s.store.Name(), os.O_WRONLY, 0644)
+	require.NoError(t, err)
+	_, err = f.WriteAt([]byte{0}, int64(pos+headerWidth))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	log, err = NewLog(dir, c)
+	require.NoError(t, err)
+	defer log.Close()
+	var b bytes.Buffer
+	require.NoError(t, log.Snapshot(&b))
+
+	restoredDir, err := ioutil.TempDir("", "snapshot-restore-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(restoredDir)
+	restored, err := NewLog(restoredDir, Config{})
+	require.NoError(t, err)
+	defer restored.Close()
+	require.NoError(t, restored.Restore(&b))
+
+	// The corrupt record is restored as it was.
+	_, err = restored.Read(0)
+	require.Equal(t, api.ErrCorruptRecord{Segment: 0, Offset: 0}, err)
+	for off := uint64(1); off < 3; off++ {
+		read, err := restored.Read(off)
+		require.NoError(t, err)
+		require.Equal(t, off, read.Offset)
+	}
+}
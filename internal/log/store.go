@@ -2,19 +2,50 @@ package log
 
 import (
 	"bufio"
+	"bytes"
+	"context"
 	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
 	"os"
 	"sync"
+	"sync/atomic"
+
+	"github.com/tysontate/gommap"
+	"go.opencensus.io/stats"
 )
 
 var (
 	// enc is the endianess used to store records.
 	enc = binary.BigEndian
+
+	// crcTable is the table used to compute the checksum of every record.
+	crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+	// errCorrupt is returned when the framing or checksum of a record doesn't match its contents.
+	errCorrupt = errors.New("corrupt record")
+	// errLegacyStore is returned when appending to a store in the old format.
+	errLegacyStore = errors.New("can't append to a store in the old format")
+
+	// storeMagic starts the header of every store, followed by its version.
+	storeMagic = []byte("PLST")
 )
 
 const (
 	// lenWidth determines how many bytes will be used to store the length of the record.
 	lenWidth = 8
+	// crcWidth determines how many bytes will be used to store the checksum of the record.
+	crcWidth = 4
+	// headerWidth is the total number of bytes that precede the record's data.
+	headerWidth = lenWidth + crcWidth
+
+	// storeVersion is the version of the store format. Stores without a header
+	// are version 1, their records are only framed by their length.
+	storeVersion = 2
+	// storeHeaderWidth is the number of bytes before the first record of a store.
+	storeHeaderWidth = 8
 )
 
 type store struct {
@@ -24,10 +55,25 @@ type store struct {
 	mu   sync.Mutex
 	buf  *bufio.Writer
 	size uint64
+	// synced is the size of the store the last time it was synced to disk.
+	synced uint64
+	// flushed is the size of the store that was handed to the operating
+	// system. Records within it can be read without holding the lock.
+	flushed uint64
+	// mmap maps the whole store when it's read only, records are sliced out of
+	// it instead of read from the file.
+	mmap gommap.MMap
+	// legacy is set on stores written before records had a checksum. They're
+	// read but never appended to.
+	legacy bool
+	// first is the position of the first record, right after the header.
+	first uint64
 }
 
 // newStore returns a ready to use store ginven a file descriptor.
-func newStore(f *os.File) (*store, error) {
+// Read only stores are mapped to memory unless they're empty.
+// New stores get their header, stores without one are in the old format.
+func newStore(f *os.File, c Config) (*store, error) {
 	// Get information for the given file descriptor.
 	fi, err := os.Stat(f.Name())
 	if err != nil {
@@ -37,22 +83,101 @@ func newStore(f *os.File) (*store, error) {
 
 	// This is useful when working with pre-existing files, which could be the case when restarting.
 	size := uint64(fi.Size())
-	return &store{
-		File: f,
-		size: size,
-		buf:  bufio.NewWriter(f),
-	}, nil
+	s := &store{
+		File:    f,
+		size:    size,
+		synced:  size,
+		flushed: size,
+		buf:     bufio.NewWriter(f),
+	}
+	if err = s.readHeader(); err != nil {
+		return nil, err
+	}
+	// A store that's too small for its header doesn't hold any record, the
+	// process stopped while it was created.
+	if !c.ReadOnly && s.size < storeHeaderWidth && !s.legacy {
+		if err = s.writeHeader(); err != nil {
+			return nil, err
+		}
+	}
+	if c.ReadOnly && s.size > 0 {
+		if s.mmap, err = gommap.Map(f.Fd(), gommap.PROT_READ, gommap.MAP_SHARED); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// readHeader checks the header of the store and finds out its format.
+func (s *store) readHeader() error {
+	if s.size < storeHeaderWidth {
+		return nil
+	}
+	header := make([]byte, storeHeaderWidth)
+	if _, err := s.File.ReadAt(header, 0); err != nil {
+		return err
+	}
+	// Stores in the old format start with the length of their first record,
+	// which is never large enough to match the magic.
+	if !bytes.Equal(header[:len(storeMagic)], storeMagic) {
+		s.legacy = true
+		return nil
+	}
+	if v := enc.Uint32(header[len(storeMagic):]); v != storeVersion {
+		return fmt.Errorf("store %s has unsupported version %d", s.File.Name(), v)
+	}
+	s.first = storeHeaderWidth
+	return nil
+}
+
+// writeHeader discards whatever is in the store and writes its header.
+func (s *store) writeHeader() error {
+	if err := s.File.Truncate(0); err != nil {
+		return err
+	}
+	header := make([]byte, storeHeaderWidth)
+	copy(header, storeMagic)
+	enc.PutUint32(header[len(storeMagic):], storeVersion)
+	// Stores are opened to append, the header can't be written at an offset.
+	if _, err := s.File.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := s.File.Write(header); err != nil {
+		return err
+	}
+	s.size = storeHeaderWidth
+	s.flushed = storeHeaderWidth
+	s.synced = 0
+	s.first = storeHeaderWidth
+	return nil
+}
+
+// frameWidth returns the number of bytes that precede the data of every record.
+func (s *store) frameWidth() uint64 {
+	if s.legacy {
+		return lenWidth
+	}
+	return headerWidth
 }
 
 // Append writes the provided bytes as a record to the end of the store.
+// Every record is framed by its length and a checksum of both the length and the data.
 // Returns the size fo the record and the position of the record within the store.
 func (s *store) Append(p []byte) (n uint64, pos uint64, err error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	if s.legacy {
+		return 0, 0, errLegacyStore
+	}
 	pos = s.size
-	// Write the size of the record before the actual record.
-	if err := binary.Write(s.buf, enc, uint64(len(p))); err != nil {
+	header := make([]byte, headerWidth)
+	enc.PutUint64(header[:lenWidth], uint64(len(p)))
+	crc := crc32.Update(crc32.Checksum(header[:lenWidth], crcTable), crcTable, p)
+	enc.PutUint32(header[lenWidth:], crc)
+
+	// Write the header of the record before the actual record.
+	if _, err := s.buf.Write(header); err != nil {
 		return 0, 0, err
 	}
 
@@ -62,42 +187,170 @@ func (s *store) Append(p []byte) (n uint64, pos uint64, err error) {
 	if err != nil {
 		return 0, 0, err
 	}
-	w += lenWidth
+	w += headerWidth
 	s.size += uint64(w)
+	// The buffer is flushed on its own once it's full.
+	atomic.StoreUint64(&s.flushed, s.size-uint64(s.buf.Buffered()))
 	return uint64(w), pos, nil
 }
 
 // Read retrieves the record at position pos from the store.
+// errCorrupt is returned if the record doesn't fit in the store or its checksum doesn't match.
+// The record of a mapped store is sliced out of the mapping, it's only valid until the store is closed.
 func (s *store) Read(pos uint64) ([]byte, error) {
+	// Flushed records don't change so they're read without holding the lock,
+	// it's only needed for the ones that could still be buffered.
+	if b, err := s.read(pos, atomic.LoadUint64(&s.flushed)); err != errCorrupt {
+		return b, err
+	}
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
 	// Commit any buffered data to the file.
-	if err := s.buf.Flush(); err != nil {
+	if err := s.flush(); err != nil {
 		return nil, err
 	}
+	return s.read(pos, s.size)
+}
 
-	// Read the size of the record at pos.
-	size := make([]byte, lenWidth)
-	// Could remove `File` because of type embedding but leaving could be better for clarity?
-	if _, err := s.File.ReadAt(size, int64(pos)); err != nil {
-		return nil, err
+// read retrieves the record at position pos from the first size bytes of the store.
+// Records in the old format don't have a checksum, only their framing is checked.
+func (s *store) read(pos, size uint64) ([]byte, error) {
+	headerWidth := s.frameWidth()
+	// Don't trust the position nor the length prefix, a torn write or a bad
+	// index could make them point past the end of the store.
+	if pos < s.first || pos+headerWidth > size {
+		return nil, errCorrupt
+	}
+
+	// Read the header of the record at pos.
+	var header []byte
+	if s.mmap != nil {
+		header = s.mmap[pos : pos+headerWidth]
+	} else {
+		header = make([]byte, headerWidth)
+		// Could remove `File` because of type embedding but leaving could be better for clarity?
+		if _, err := s.File.ReadAt(header, int64(pos)); err != nil {
+			return nil, err
+		}
+	}
+	n := enc.Uint64(header[:lenWidth])
+	if n > size-pos-headerWidth {
+		return nil, errCorrupt
 	}
 
 	// Read the actual record data given its offset and size.
-	b := make([]byte, enc.Uint64(size))
-	// Could remove `File` because of type embedding but leaving could be better for clarity?
-	if _, err := s.File.ReadAt(b, int64(pos+lenWidth)); err != nil {
-		return nil, err
+	var b []byte
+	if s.mmap != nil {
+		b = s.mmap[pos+headerWidth : pos+headerWidth+n]
+	} else {
+		b = make([]byte, n)
+		// Could remove `File` because of type embedding but leaving could be better for clarity?
+		if _, err := s.File.ReadAt(b, int64(pos+headerWidth)); err != nil {
+			return nil, err
+		}
+	}
+
+	if s.legacy {
+		return b, nil
+	}
+	crc := crc32.Update(crc32.Checksum(header[:lenWidth], crcTable), crcTable, b)
+	if crc != enc.Uint32(header[lenWidth:]) {
+		return nil, errCorrupt
 	}
 	return b, nil
 }
 
-func (s *store) ReadAt(p []byte, off int64) (int, error) {
+// recordWidth returns the number of bytes taken by the record at pos, framing
+// included, without checking its checksum. The returned bool is false if the
+// record doesn't fit in the store.
+func (s *store) recordWidth(pos uint64) (uint64, bool, error) {
+	if pos+s.frameWidth() > s.size {
+		return 0, false, nil
+	}
+	b := make([]byte, lenWidth)
+	if _, err := s.ReadAt(b, int64(pos)); err != nil {
+		return 0, false, err
+	}
+	n := enc.Uint64(b)
+	if n > s.size-pos-s.frameWidth() {
+		return 0, false, nil
+	}
+	return s.frameWidth() + n, true, nil
+}
+
+// Flush hands the buffered records to the operating system.
+func (s *store) Flush() error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	return s.flush()
+}
+
+// flush hands the buffered records to the operating system. It must be called while holding the lock.
+func (s *store) flush() error {
 	if err := s.buf.Flush(); err != nil {
+		return err
+	}
+	atomic.StoreUint64(&s.flushed, s.size)
+	return nil
+}
+
+// Sync commits the records appended so far to disk.
+func (s *store) Sync() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.synced == s.size {
+		return nil
+	}
+	if err := s.flush(); err != nil {
+		return err
+	}
+	if err := s.File.Sync(); err != nil {
+		return err
+	}
+	stats.Record(context.Background(), storeSyncs.M(1))
+	s.synced = s.size
+	return nil
+}
+
+// Truncate discards every byte of the store from size onwards.
+func (s *store) Truncate(size uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.flush(); err != nil {
+		return err
+	}
+	if err := s.File.Truncate(int64(size)); err != nil {
+		return err
+	}
+	s.size = size
+	atomic.StoreUint64(&s.flushed, size)
+	if s.synced > size {
+		s.synced = size
+	}
+	return nil
+}
+
+func (s *store) ReadAt(p []byte, off int64) (int, error) {
+	if s.mmap != nil {
+		if off >= int64(len(s.mmap)) {
+			return 0, io.EOF
+		}
+		n := copy(p, s.mmap[off:])
+		if n < len(p) {
+			return n, io.EOF
+		}
+		return n, nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.flush(); err != nil {
 		return 0, err
 	}
 
@@ -109,8 +362,15 @@ func (s *store) Close() error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	if err := s.buf.Flush(); err != nil {
+	if err := s.flush(); err != nil {
 		return err
 	}
+	// Nothing reads from the mapping once the store is closed.
+	if s.mmap != nil {
+		if err := s.mmap.UnsafeUnmap(); err != nil {
+			return err
+		}
+		s.mmap = nil
+	}
 	return s.File.Close()
 }
@@ -10,7 +10,7 @@ import (
 
 var (
 	write = []byte("hello world")
-	width = uint64(len(write) + lenWidth)
+	width = uint64(len(write) + headerWidth)
 )
 
 func TestStoreAppendRead(t *testing.T) {
@@ -20,7 +20,7 @@ func TestStoreAppendRead(t *testing.T) {
 	defer os.Remove(f.Name())
 
 	// Create a new store from the file.
-	s, err := newStore(f)
+	s, err := newStore(f, Config{})
 	require.NoError(t, err)
 
 	// Basic operations.
@@ -29,7 +29,7 @@ func TestStoreAppendRead(t *testing.T) {
 	testReadAt(t, s)
 
 	// A store can be created from an existing non-empty file.
-	s, err = newStore(f)
+	s, err = newStore(f, Config{})
 	require.NoError(t, err)
 	testRead(t, s)
 }
@@ -39,14 +39,14 @@ func testAppend(t *testing.T, s *store) {
 	for i := uint64(1); i < 4; i++ {
 		n, pos, err := s.Append(write)
 		require.NoError(t, err)
-		// test the returned offsets.
-		require.Equal(t, width*i, pos+n)
+		// test the returned offsets, records come after the header.
+		require.Equal(t, storeHeaderWidth+width*i, pos+n)
 	}
 }
 
 func testRead(t *testing.T, s *store) {
 	t.Helper()
-	var pos uint64
+	pos := uint64(storeHeaderWidth)
 	for i := uint64(1); i < 4; i++ {
 		read, err := s.Read(pos)
 		require.NoError(t, err)
@@ -57,17 +57,17 @@ func testRead(t *testing.T, s *store) {
 
 func testReadAt(t *testing.T, s *store) {
 	t.Helper()
-	for i, off := uint64(1), int64(0); i < 4; i++ {
-		// Read the size of the record at the current offset.
-		b := make([]byte, lenWidth)
+	for i, off := uint64(1), int64(storeHeaderWidth); i < 4; i++ {
+		// Read the header of the record at the current offset.
+		b := make([]byte, headerWidth)
 		n, err := s.File.ReadAt(b, off)
 		require.NoError(t, err)
-		// bytes read same as predifined byte size for length.
-		require.Equal(t, lenWidth, n)
+		// bytes read same as predifined byte size for the header.
+		require.Equal(t, headerWidth, n)
 		off += int64(n)
 
 		// Read the actual content of the record.
-		size := enc.Uint64(b)
+		size := enc.Uint64(b[:lenWidth])
 		b = make([]byte, size)
 		n, err = s.ReadAt(b, off)
 		require.NoError(t, err)
@@ -79,12 +79,87 @@ func testReadAt(t *testing.T, s *store) {
 	}
 }
 
+func TestStoreCorruption(t *testing.T) {
+	f, err := ioutil.TempFile("", "store_corruption_test")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+
+	s, err := newStore(f, Config{})
+	require.NoError(t, err)
+
+	_, pos, err := s.Append(write)
+	require.NoError(t, err)
+	_, err = s.Read(pos)
+	require.NoError(t, err)
+
+	// Reading past the end of the store is reported as corruption.
+	_, err = s.Read(pos + width)
+	require.Equal(t, errCorrupt, err)
+
+	// Flip a bit of the record's data.
+	require.NoError(t, s.buf.Flush())
+	_, err = f.WriteAt([]byte{write[0] ^ 1}, int64(pos+headerWidth))
+	require.NoError(t, err)
+	_, err = s.Read(pos)
+	require.Equal(t, errCorrupt, err, "A record whose data doesn't match its checksum is corrupt.")
+
+	// A length prefix that points past the end of the store must not be trusted.
+	b := make([]byte, lenWidth)
+	enc.PutUint64(b, 1<<40)
+	_, err = f.WriteAt(b, int64(pos))
+	require.NoError(t, err)
+	_, err = s.Read(pos)
+	require.Equal(t, errCorrupt, err, "A record longer than the store is corrupt.")
+}
+
+func TestStoreFormat(t *testing.T) {
+	f, err := ioutil.TempFile("", "store_format_test")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+
+	// Stores written before the header only frame records by their length.
+	legacy := make([]byte, lenWidth)
+	enc.PutUint64(legacy, uint64(len(write)))
+	legacy = append(legacy, write...)
+	_, err = f.Write(legacy)
+	require.NoError(t, err)
+
+	s, err := newStore(f, Config{})
+	require.NoError(t, err)
+	require.True(t, s.legacy)
+	read, err := s.Read(0)
+	require.NoError(t, err)
+	require.Equal(t, write, read)
+	_, err = s.Read(uint64(len(legacy)))
+	require.Equal(t, errCorrupt, err)
+	_, _, err = s.Append(write)
+	require.Equal(t, errLegacyStore, err, "Records shouldn't be appended in the old format.")
+
+	// New stores get a header with the version.
+	require.NoError(t, f.Truncate(0))
+	s, err = newStore(f, Config{})
+	require.NoError(t, err)
+	require.False(t, s.legacy)
+	require.Equal(t, uint64(storeHeaderWidth), s.size)
+	header := make([]byte, storeHeaderWidth)
+	_, err = f.ReadAt(header, 0)
+	require.NoError(t, err)
+	require.Equal(t, storeMagic, header[:len(storeMagic)])
+
+	// Versions that aren't known can't be read.
+	enc.PutUint32(header[len(storeMagic):], storeVersion+1)
+	_, err = f.WriteAt(header, 0)
+	require.NoError(t, err)
+	_, err = newStore(f, Config{})
+	require.Error(t, err)
+}
+
 func TestStoreClose(t *testing.T) {
 	f, err := ioutil.TempFile("", "store_close_test")
 	require.NoError(t, err)
 	defer os.Remove(f.Name())
 
-	s, err := newStore(f)
+	s, err := newStore(f, Config{})
 	require.NoError(t, err)
 
 	// Append a single record.
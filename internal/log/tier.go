@@ -0,0 +1,440 @@
+package log
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"sync"
+	"time"
+
+	api "github.com/AYM1607/proglog/api/v1"
+	"go.uber.org/zap"
+)
+
+const (
+	// defaultTieringInterval is how often segments are checked for offloading if the config doesn't say.
+	defaultTieringInterval = time.Minute
+	// defaultCacheSegments is how many offloaded segments are cached if the config doesn't say.
+	defaultCacheSegments = 4
+	// remoteDir is the directory, inside the log's, where offloaded segments are cached.
+	remoteDir = ".remote"
+	// remoteSegmentExt is the extension of the object that describes an offloaded segment.
+	remoteSegmentExt = ".segment"
+)
+
+// remoteSegment describes a segment that was offloaded to the object store.
+// It's written to the store after the segment's files so a segment is only
+// considered offloaded once all of them are there.
+type remoteSegment struct {
+	BaseOffset     uint64 `json:"base_offset"`
+	NextOffset     uint64 `json:"next_offset"`
+	LastAppendTime int64  `json:"last_append_time"`
+	// Size is the size of the segment's store in bytes.
+	Size uint64 `json:"size"`
+}
+
+// objects returns the names of the objects that make up the segment, the description goes first.
+func (rs remoteSegment) objects() []string {
+	var names []string
+	for _, ext := range append([]string{remoteSegmentExt}, segmentExts...) {
+		names = append(names, fmt.Sprintf("%d%s", rs.BaseOffset, ext))
+	}
+	return names
+}
+
+// Offload moves the sealed segments whose last record is older than the
+// configured max local age to the object store. Segments are offloaded from
+// the start of the log so the offloaded ones always come before the local ones.
+func (l *Log) Offload() error {
+	if l.Config.ReadOnly {
+		return ErrReadOnly
+	}
+	store := l.Config.Tiering.Store
+	if store == nil {
+		return nil
+	}
+
+	// Compacted segments are replaced, keep them in place until it's done.
+	l.compactMu.Lock()
+	defer l.compactMu.Unlock()
+
+	deadline := time.Now().Add(-l.Config.Tiering.MaxLocalAge).UnixNano()
+	for {
+		l.mu.RLock()
+		s := l.segments[0]
+		old := len(l.segments) > 1 && s.lastAppendTime() < deadline
+		l.mu.RUnlock()
+		if !old {
+			return nil
+		}
+
+		rs, err := l.upload(s)
+		// The segment was removed before it could be uploaded.
+		if err == errRetired {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		l.mu.Lock()
+		// The segment could have been removed while it was uploaded, or
+		// reopened which leaves it at the same base offset.
+		if l.segments[0].baseOffset != rs.BaseOffset {
+			l.mu.Unlock()
+			if err = deleteRemote(store, rs); err != nil {
+				return err
+			}
+			continue
+		}
+		s = l.segments[0]
+		segments := make([]*segment, len(l.segments)-1)
+		copy(segments, l.segments[1:])
+		l.segments = segments
+		l.remote = append(l.remote, rs)
+		l.publish()
+		// Reads in progress keep the local segment open until they're done.
+		err = s.Remove()
+		l.mu.Unlock()
+		if err != nil {
+			return err
+		}
+
+		l.logger.Info(
+			"offloaded segment",
+			zap.Uint64("segment", rs.BaseOffset),
+			zap.Uint64("next_offset", rs.NextOffset),
+			zap.Uint64("bytes", rs.Size),
+		)
+	}
+}
+
+// upload writes the files of the sealed segment s to the object store. The
+// segment is only referenced while it's uploaded so the log can be used in the
+// meantime. errRetired is returned if it left the log before it was uploaded.
+func (l *Log) upload(s *segment) (remoteSegment, error) {
+	store := l.Config.Tiering.Store
+	var rs remoteSegment
+	uploaded := false
+	err := l.withSegment(s, func(s *segment) error {
+		uploaded = true
+		rs = remoteSegment{
+			BaseOffset:     s.baseOffset,
+			NextOffset:     s.nextOffset,
+			LastAppendTime: s.lastAppendTime(),
+			Size:           s.store.size,
+		}
+		if err := store.Put(path.Base(s.store.Name()), io.NewSectionReader(s.store, 0, int64(rs.Size))); err != nil {
+			return err
+		}
+		for _, idx := range []*index{s.index, s.timeIndex} {
+			if err := store.Put(path.Base(idx.Name()), bytes.NewReader(idx.Bytes())); err != nil {
+				return err
+			}
+		}
+		b, err := json.Marshal(rs)
+		if err != nil {
+			return err
+		}
+		return store.Put(rs.objects()[0], bytes.NewReader(b))
+	})
+	if err == nil && !uploaded {
+		err = errRetired
+	}
+	return rs, err
+}
+
+// deleteRemote removes an offloaded segment from the object store.
+func deleteRemote(store ObjectStore, rs remoteSegment) error {
+	for _, name := range rs.objects() {
+		if err := store.Delete(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// tierLoop offloads segments on every configured interval until the log is closed.
+func (l *Log) tierLoop() {
+	defer l.wg.Done()
+
+	interval := l.Config.Tiering.Interval
+	if interval == 0 {
+		interval = defaultTieringInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.done:
+			return
+		case <-ticker.C:
+			if err := l.Offload(); err != nil {
+				l.logger.Error("offload failed", zap.Error(err))
+			}
+		}
+	}
+}
+
+// remoteSegments returns the segments that were offloaded to the object store, in order.
+func (l *Log) remoteSegments() ([]remoteSegment, error) {
+	store := l.Config.Tiering.Store
+	if store == nil {
+		return nil, nil
+	}
+	names, err := store.List()
+	if err != nil {
+		return nil, err
+	}
+	var remote []remoteSegment
+	for _, name := range names {
+		if path.Ext(name) != remoteSegmentExt {
+			continue
+		}
+		rs, err := getRemote(store, name)
+		if err != nil {
+			return nil, err
+		}
+		remote = append(remote, rs)
+	}
+	sort.Slice(remote, func(i, j int) bool {
+		return remote[i].BaseOffset < remote[j].BaseOffset
+	})
+	return remote, nil
+}
+
+// setupRemote keeps track of the offloaded segments that come before the
+// local ones and prepares the cache they're read through. It must be called
+// after the local segments are set up.
+func (l *Log) setupRemote(remote []remoteSegment) error {
+	store := l.Config.Tiering.Store
+	l.remote = nil
+	if store == nil {
+		return nil
+	}
+
+	for _, rs := range remote {
+		// The process stopped before the local segment was removed, keep that one.
+		if rs.BaseOffset >= l.segments[0].baseOffset {
+			if !l.Config.ReadOnly {
+				if err := deleteRemote(store, rs); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		l.remote = append(l.remote, rs)
+	}
+
+	if l.cache != nil {
+		return nil
+	}
+	// Read only logs don't write to their directory.
+	dir := path.Join(l.Dir, remoteDir)
+	var err error
+	if l.Config.ReadOnly {
+		if dir, err = ioutil.TempDir("", "log-remote-"); err != nil {
+			return err
+		}
+	} else if err = os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	max := l.Config.Tiering.CacheSegments
+	if max == 0 {
+		max = defaultCacheSegments
+	}
+	c := l.Config
+	c.ReadOnly = true
+	l.cache = &segmentCache{
+		dir:     dir,
+		store:   store,
+		max:     max,
+		config:  c,
+		entries: make(map[uint64]*cacheEntry),
+	}
+	return nil
+}
+
+// getRemote reads the description of an offloaded segment.
+func getRemote(store ObjectStore, name string) (remoteSegment, error) {
+	var rs remoteSegment
+	r, err := store.Get(name)
+	if err != nil {
+		return rs, err
+	}
+	defer r.Close()
+	err = json.NewDecoder(r).Decode(&rs)
+	return rs, err
+}
+
+// readRemote returns the first record at or after off in the offloaded
+// segments of the view, fetching them as needed. io.EOF is returned if there
+// isn't one. It must be called without holding the lock as fetching a segment
+// can take a while.
+func (v *segmentView) readRemote(off uint64) (*api.Record, error) {
+	for _, rs := range v.remoteFrom(off) {
+		s, release, err := v.cache.get(rs.BaseOffset)
+		if err != nil {
+			return nil, err
+		}
+		record, err := s.Read(off)
+		release()
+		// The rest of the segment was compacted, move on to the next one.
+		if err == io.EOF {
+			continue
+		}
+		return record, err
+	}
+	return nil, io.EOF
+}
+
+// offsetForTimeRemote returns the offset of the first record appended at or
+// after t in the offloaded segments of the view. The returned bool is false if
+// every record in them was appended before t.
+func (v *segmentView) offsetForTimeRemote(t time.Time) (uint64, bool, error) {
+	for _, rs := range v.remote {
+		if rs.LastAppendTime < t.UnixNano() {
+			continue
+		}
+		s, release, err := v.cache.get(rs.BaseOffset)
+		if err != nil {
+			return 0, false, err
+		}
+		off, ok := s.OffsetForTime(t)
+		release()
+		if ok {
+			return off, true, nil
+		}
+	}
+	return 0, false, nil
+}
+
+// segmentCache keeps a bounded number of offloaded segments locally so they
+// can be read. The least recently used ones are removed first, as long as
+// nobody is reading them.
+type segmentCache struct {
+	mu      sync.Mutex
+	dir     string
+	store   ObjectStore
+	max     int
+	config  Config
+	entries map[uint64]*cacheEntry
+	// clock orders the uses of the entries.
+	clock uint64
+}
+
+type cacheEntry struct {
+	s    *segment
+	refs int
+	used uint64
+	// ready is closed once the segment was fetched, or failed to be in which case err is set.
+	ready chan struct{}
+	err   error
+}
+
+// get returns the offloaded segment with the given base offset, fetching it if
+// it isn't cached. release must be called once the segment isn't used anymore.
+func (c *segmentCache) get(baseOffset uint64) (s *segment, release func(), err error) {
+	c.mu.Lock()
+	c.clock++
+	e, ok := c.entries[baseOffset]
+	if !ok {
+		e = &cacheEntry{ready: make(chan struct{})}
+		c.entries[baseOffset] = e
+	}
+	e.refs++
+	e.used = c.clock
+	c.mu.Unlock()
+
+	release = func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		e.refs--
+		c.evict()
+	}
+
+	if ok {
+		<-e.ready
+	} else {
+		s, err := c.fetch(baseOffset)
+		c.mu.Lock()
+		e.s, e.err = s, err
+		close(e.ready)
+		c.evict()
+		c.mu.Unlock()
+	}
+	if e.err != nil {
+		c.mu.Lock()
+		if c.entries[baseOffset] == e {
+			delete(c.entries, baseOffset)
+		}
+		c.mu.Unlock()
+		return nil, nil, e.err
+	}
+	return e.s, release, nil
+}
+
+// fetch downloads the files of an offloaded segment and opens it.
+func (c *segmentCache) fetch(baseOffset uint64) (*segment, error) {
+	for _, ext := range segmentExts {
+		name := fmt.Sprintf("%d%s", baseOffset, ext)
+		if err := c.download(name); err != nil {
+			return nil, err
+		}
+	}
+	return newSegment(c.dir, baseOffset, c.config)
+}
+
+func (c *segmentCache) download(name string) error {
+	r, err := c.store.Get(name)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	f, err := os.Create(path.Join(c.dir, name))
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// evict removes the least recently used segments that aren't being read
+// until the cache is within its size. It must be called while holding the lock.
+func (c *segmentCache) evict() {
+	for len(c.entries) > c.max {
+		var oldest *cacheEntry
+		var baseOffset uint64
+		for off, e := range c.entries {
+			if e.refs == 0 && e.s != nil && (oldest == nil || e.used < oldest.used) {
+				oldest, baseOffset = e, off
+			}
+		}
+		if oldest == nil {
+			return
+		}
+		delete(c.entries, baseOffset)
+		oldest.s.Remove()
+	}
+}
+
+// close removes every cached segment.
+func (c *segmentCache) close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for off, e := range c.entries {
+		if e.s != nil {
+			e.s.Close()
+		}
+		delete(c.entries, off)
+	}
+	return os.RemoveAll(c.dir)
+}
@@ -0,0 +1,218 @@
+package log
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	api "github.com/AYM1607/proglog/api/v1"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTiering(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tier-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	storeDir, err := ioutil.TempDir("", "tier-store-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(storeDir)
+	store, err := NewLocalObjectStore(storeDir)
+	require.NoError(t, err)
+
+	c := Config{}
+	// This ensures that each segment can only hold one record.
+	c.Segment.MaxIndexBytes = entWidth
+	c.Tiering.Store = store
+	c.Tiering.MaxLocalAge = time.Nanosecond
+	c.Tiering.CacheSegments = 2
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+
+	appendRecords(t, log, 5)
+	first, err := log.Read(0)
+	require.NoError(t, err)
+	require.NoError(t, log.Offload())
+
+	require.Len(t, log.segments, 1, "Every sealed segment should be offloaded.")
+	_, err = os.Stat(path.Join(dir, "0.store"))
+	require.True(t, os.IsNotExist(err))
+	_, err = os.Stat(path.Join(storeDir, "0.store"))
+	require.NoError(t, err)
+
+	// Offloaded segments are still part of the log.
+	off, err := log.LowestOffset()
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), off)
+	for off := uint64(0); off < 5; off++ {
+		read, err := log.Read(off)
+		require.NoError(t, err)
+		require.Equal(t, off, read.Offset)
+	}
+	require.LessOrEqual(t, len(log.cache.entries), 2, "The cache should be bounded.")
+
+	it := log.NewIterator(0)
+	for want := uint64(0); want < 5; want++ {
+		record, err := it.Next()
+		require.NoError(t, err)
+		require.Equal(t, want, record.Offset)
+	}
+	_, err = it.Next()
+	require.Equal(t, io.EOF, err)
+
+	off, err = log.OffsetForTime(time.Unix(0, first.AppendTime))
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), off)
+
+	// Offloaded segments are found again on restart, whatever was left in the
+	// cache by a crash is removed.
+	require.NoError(t, log.Close())
+	stale := path.Join(dir, remoteDir, "0.store")
+	require.NoError(t, os.MkdirAll(path.Dir(stale), 0755))
+	require.NoError(t, ioutil.WriteFile(stale, []byte("stale"), 0644))
+	log, err = NewLog(dir, c)
+	require.NoError(t, err)
+	defer log.Close()
+	_, err = os.Stat(stale)
+	require.True(t, os.IsNotExist(err))
+	read, err := log.Read(1)
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), read.Offset)
+
+	require.NoError(t, log.Truncate(2))
+	off, err = log.LowestOffset()
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), off)
+	_, err = os.Stat(path.Join(storeDir, "1.store"))
+	require.True(t, os.IsNotExist(err), "Truncated segments should be removed from the store.")
+
+	// Retention deletes the offloaded segments first.
+	log.Config.Retention.MaxAge = time.Nanosecond
+	require.NoError(t, log.retain())
+	off, err = log.LowestOffset()
+	require.NoError(t, err)
+	require.Equal(t, uint64(5), off)
+	names, err := store.List()
+	require.NoError(t, err)
+	require.Empty(t, names)
+}
+
+func TestSnapshotTiered(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tier-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	storeDir, err := ioutil.TempDir("", "tier-store-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(storeDir)
+	store, err := NewLocalObjectStore(storeDir)
+	require.NoError(t, err)
+
+	c := Config{}
+	c.Segment.MaxIndexBytes = entWidth
+	c.Tiering.Store = store
+	c.Tiering.MaxLocalAge = time.Nanosecond
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+	defer log.Close()
+	appendRecords(t, log, 3)
+	require.NoError(t, log.Offload())
+	require.Len(t, log.remote, 3)
+
+	var b bytes.Buffer
+	require.NoError(t, log.Snapshot(&b))
+
+	restoredDir, err := ioutil.TempDir("", "tier-restore-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(restoredDir)
+	restored, err := NewLog(restoredDir, Config{})
+	require.NoError(t, err)
+	defer restored.Close()
+	require.NoError(t, restored.Restore(&b))
+
+	// Offloaded segments are restored as local ones.
+	require.Empty(t, restored.remote)
+	for off := uint64(0); off < 3; off++ {
+		read, err := restored.Read(off)
+		require.NoError(t, err)
+		require.Equal(t, off, read.Offset)
+	}
+}
+
+// blockingStore is an object store whose deletes wait until unblock is
+// closed. The first one is reported on deleting.
+type blockingStore struct {
+	ObjectStore
+	deleting chan struct{}
+	unblock  chan struct{}
+}
+
+func (s *blockingStore) Delete(name string) error {
+	select {
+	case s.deleting <- struct{}{}:
+	default:
+	}
+	<-s.unblock
+	return s.ObjectStore.Delete(name)
+}
+
+func TestDeleteRemoteDoesntBlockAppends(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tier-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	storeDir, err := ioutil.TempDir("", "tier-store-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(storeDir)
+	store, err := NewLocalObjectStore(storeDir)
+	require.NoError(t, err)
+
+	c := Config{}
+	c.Segment.MaxIndexBytes = entWidth
+	c.Tiering.Store = store
+	c.Tiering.MaxLocalAge = time.Nanosecond
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+	defer log.Close()
+	appendRecords(t, log, 3)
+	require.NoError(t, log.Offload())
+	log.Config.Retention.MaxAge = time.Nanosecond
+
+	for _, remove := range []func() error{
+		func() error { return log.Truncate(1) },
+		log.retain,
+	} {
+		blocking := &blockingStore{
+			ObjectStore: store,
+			deleting:    make(chan struct{}, 1),
+			unblock:     make(chan struct{}),
+		}
+		log.Config.Tiering.Store = blocking
+		removed := make(chan error)
+		go func() {
+			removed <- remove()
+		}()
+		<-blocking.deleting
+
+		appended := make(chan struct{})
+		go func() {
+			defer close(appended)
+			if _, err := log.Append(&api.Record{Value: []byte("hello world")}); err != nil {
+				t.Error(err)
+			}
+		}()
+		select {
+		case <-appended:
+		case <-time.After(5 * time.Second):
+			t.Error("Appends shouldn't wait for the object store.")
+		}
+		close(blocking.unblock)
+		require.NoError(t, <-removed)
+		<-appended
+	}
+
+	names, err := store.List()
+	require.NoError(t, err)
+	require.Empty(t, names)
+}
@@ -0,0 +1,213 @@
+package log
+
+import (
+	"io"
+	"os"
+	"sort"
+
+	api "github.com/AYM1607/proglog/api/v1"
+	"go.uber.org/zap"
+)
+
+// segmentView is an immutable copy of the segments of the log. A new one is
+// published every time they change so reads don't need to take the lock.
+type segmentView struct {
+	segments []*segment
+	remote   []remoteSegment
+	cache    *segmentCache
+}
+
+// publish makes the current segments visible to reads. It must be called while
+// holding the write lock, after the segments change and before the ones that
+// left the log are closed or removed. Segments over the max number of open
+// ones are closed along the way.
+func (l *Log) publish() {
+	evicted := l.evict()
+	v := &segmentView{
+		segments: make([]*segment, len(l.segments)),
+		remote:   make([]remoteSegment, len(l.remote)),
+		cache:    l.cache,
+	}
+	copy(v.segments, l.segments)
+	copy(v.remote, l.remote)
+	l.view.Store(v)
+
+	// Reads in progress keep the evicted segments open until they're done.
+	for _, s := range evicted {
+		if err := s.Close(); err != nil {
+			l.logger.Error("close segment failed", zap.Uint64("segment", s.baseOffset), zap.Error(err))
+		}
+	}
+}
+
+// evict replaces the least recently read sealed segments with closed copies
+// until no more than the configured max have their files open, and returns
+// the ones that were replaced. It must be called while holding the write lock.
+func (l *Log) evict() []*segment {
+	max := l.Config.Segment.MaxOpenSegments
+	if max == 0 {
+		return nil
+	}
+	var evicted []*segment
+	for {
+		open, oldest := 0, -1
+		for i, s := range l.segments {
+			// Segments that weren't sealed yet are still written to.
+			if s == l.activeSegment || s.closed || !s.config.ReadOnly {
+				continue
+			}
+			open++
+			if oldest == -1 || s.lastUsed() < l.segments[oldest].lastUsed() {
+				oldest = i
+			}
+		}
+		if open <= max {
+			return evicted
+		}
+		evicted = append(evicted, l.segments[oldest])
+		l.segments[oldest] = l.segments[oldest].closedCopy()
+	}
+}
+
+// open returns the segment of the log with the base offset of s with its files
+// open, opening them again if they were closed. It takes a reference to the
+// returned segment, which must be released. errRetired is returned if the
+// segment left the log. It must be called without holding the lock.
+func (l *Log) open(s *segment) (*segment, error) {
+	if !s.closed && s.acquire() {
+		s.touch()
+		return s, nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return nil, errRetired
+	}
+	i := sort.Search(len(l.segments), func(i int) bool {
+		return l.segments[i].baseOffset >= s.baseOffset
+	})
+	if i == len(l.segments) || l.segments[i].baseOffset != s.baseOffset {
+		return nil, errRetired
+	}
+	if l.segments[i].closed {
+		if err := l.reopen(i); err != nil {
+			return nil, err
+		}
+	}
+	s = l.segments[i]
+	s.acquire()
+	s.touch()
+	return s, nil
+}
+
+// reopen opens the files of the closed segment at position i again. It must be
+// called while holding the write lock.
+func (l *Log) reopen(i int) error {
+	closed := l.segments[i]
+	s, err := newSegment(closed.dir, closed.baseOffset, closed.config)
+	if err != nil {
+		return err
+	}
+	// Keep it from being evicted right away.
+	s.touch()
+	l.segments[i] = s
+	l.publish()
+	return closed.Close()
+}
+
+// release drops the reference taken by open.
+func (l *Log) release(s *segment) {
+	if err := s.release(); err != nil {
+		l.logger.Error("close segment failed", zap.Uint64("segment", s.baseOffset), zap.Error(err))
+	}
+}
+
+// retry calls fn with the view, and the newest one after that, until fn
+// doesn't fail because one of the segments it read left the log.
+func (l *Log) retry(v *segmentView, fn func(v *segmentView) error) error {
+	for {
+		err := fn(v)
+		if err != errRetired {
+			return err
+		}
+		cur := l.loadView()
+		// Segments only leave the log without a new view when it's closed.
+		if cur == v {
+			return os.ErrClosed
+		}
+		v = cur
+	}
+}
+
+// loadView returns the segments that were last published.
+func (l *Log) loadView() *segmentView {
+	return l.view.Load().(*segmentView)
+}
+
+// readLocal returns the first record at or after off in the local segments of
+// the view. errRetired is returned if one of them left the log before it could
+// be read, a newer view was published by then.
+func (l *Log) readLocal(v *segmentView, off uint64) (*api.Record, error) {
+	if off < v.segments[0].baseOffset {
+		return nil, v.outOfRange(off)
+	}
+	// Start from the last segment whose base offset isn't after off.
+	i := sort.Search(len(v.segments), func(i int) bool {
+		return v.segments[i].baseOffset > off
+	}) - 1
+	for _, s := range v.segments[i:] {
+		s, err := l.open(s)
+		if err != nil {
+			return nil, err
+		}
+		record, err := s.Read(off)
+		l.release(s)
+		// The rest of the segment was compacted, move on to the next one.
+		if err == io.EOF {
+			continue
+		}
+		return record, err
+	}
+	return nil, v.outOfRange(off)
+}
+
+// read returns the first record at or after off in the local segments,
+// retrying with the newest view if a segment left the log while it was read.
+func (l *Log) read(v *segmentView, off uint64) (*api.Record, error) {
+	var record *api.Record
+	err := l.retry(v, func(v *segmentView) error {
+		var err error
+		record, err = l.readLocal(v, off)
+		return err
+	})
+	return record, err
+}
+
+// lowestOffset returns the offset of the first record of the view.
+func (v *segmentView) lowestOffset() uint64 {
+	if len(v.remote) > 0 {
+		return v.remote[0].BaseOffset
+	}
+	return v.segments[0].baseOffset
+}
+
+// outOfRange returns the error for reading off along with the range of the view.
+func (v *segmentView) outOfRange(off uint64) api.ErrOffsetOutOfRange {
+	err := api.ErrOffsetOutOfRange{
+		Offset: off,
+		Lowest: v.lowestOffset(),
+	}
+	if next := v.segments[len(v.segments)-1].loadNextOffset(); next > 0 {
+		err.Highest = next - 1
+	}
+	return err
+}
+
+// remoteFrom returns the offloaded segments that could hold records at or after off.
+func (v *segmentView) remoteFrom(off uint64) []remoteSegment {
+	i := sort.Search(len(v.remote), func(i int) bool {
+		return v.remote[i].NextOffset > off
+	})
+	return v.remote[i:]
+}
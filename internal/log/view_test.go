@@ -0,0 +1,236 @@
+package log
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sync"
+	"testing"
+	"time"
+
+	api "github.com/AYM1607/proglog/api/v1"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConcurrentReads(t *testing.T) {
+	dir, err := ioutil.TempDir("", "view-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := Config{}
+	// Every few records roll a segment so reads cross new views.
+	c.Segment.MaxIndexBytes = entWidth * 3
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+	defer log.Close()
+
+	const records = 300
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				highest, err := log.HighestOffset()
+				require.NoError(t, err)
+				read, err := log.Read(highest)
+				if _, ok := err.(api.ErrOffsetOutOfRange); ok {
+					// Nothing was appended yet or it was just truncated.
+					continue
+				}
+				require.NoError(t, err)
+				require.Equal(t, highest, read.Offset)
+			}
+		}()
+	}
+
+	for i := 0; i < records; i++ {
+		_, err := log.Append(&api.Record{Value: []byte("hello world")})
+		require.NoError(t, err)
+		if i%50 == 49 {
+			require.NoError(t, log.Truncate(uint64(i-10)))
+		}
+	}
+	close(done)
+	wg.Wait()
+}
+
+func TestReadRemovedSegment(t *testing.T) {
+	dir, err := ioutil.TempDir("", "view-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := Config{}
+	// This ensures that each segment can only hold one record.
+	c.Segment.MaxIndexBytes = entWidth
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+	defer log.Close()
+	appendRecords(t, log, 3)
+
+	// A read that got to the segment before it was removed can finish.
+	v := log.loadView()
+	s := v.segments[0]
+	require.True(t, s.acquire())
+	require.NoError(t, log.Truncate(1))
+	read, err := s.Read(0)
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), read.Offset)
+	require.NoError(t, s.release())
+	require.False(t, s.acquire(), "The segment should be closed by the last read.")
+
+	// Reads through the old view move on to the current one.
+	read, err = log.read(v, 1)
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), read.Offset)
+	_, err = log.read(v, 0)
+	require.Error(t, err)
+	require.True(t, err.(api.ErrOffsetOutOfRange).Truncated())
+}
+
+func TestMaxOpenSegments(t *testing.T) {
+	dir, err := ioutil.TempDir("", "view-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := Config{}
+	// This ensures that each segment can only hold one record.
+	c.Segment.MaxIndexBytes = entWidth
+	c.Segment.MaxOpenSegments = 2
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+	appendRecords(t, log, 10)
+
+	open := func(log *Log) int {
+		n := 0
+		for _, s := range log.loadView().segments {
+			if !s.closed {
+				n++
+			}
+		}
+		return n
+	}
+	// The active segment is always open.
+	require.Equal(t, 3, open(log))
+	require.True(t, log.segments[0].closed)
+
+	for off := uint64(0); off < 10; off++ {
+		read, err := log.Read(off)
+		require.NoError(t, err)
+		require.Equal(t, off, read.Offset)
+		require.LessOrEqual(t, open(log), 3)
+	}
+	require.False(t, log.segments[9].closed, "The last read segment should be open.")
+
+	it := log.NewIterator(0)
+	for want := uint64(0); want < 10; want++ {
+		record, err := it.Next()
+		require.NoError(t, err)
+		require.Equal(t, want, record.Offset)
+	}
+
+	b, err := ioutil.ReadAll(log.Reader())
+	require.NoError(t, err)
+	var size uint64
+	for _, s := range log.segments {
+		size += s.storeSize()
+	}
+	require.Equal(t, size, uint64(len(b)))
+
+	off, err := log.OffsetForTime(time.Unix(0, 0))
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), off)
+
+	// Closed segments are opened for as long as they're compacted or copied.
+	require.NoError(t, log.Compact())
+	require.NoError(t, log.Snapshot(ioutil.Discard))
+	require.LessOrEqual(t, open(log), 3)
+
+	require.NoError(t, log.Truncate(2))
+	_, err = log.Read(1)
+	require.Error(t, err)
+	read, err := log.Read(2)
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), read.Offset)
+
+	// Segments are closed as they're opened on restart.
+	require.NoError(t, log.Close())
+	log, err = NewLog(dir, c)
+	require.NoError(t, err)
+	defer log.Close()
+	require.Equal(t, 3, open(log))
+	read, err = log.Read(3)
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), read.Offset)
+}
+
+func TestMaxOpenSegmentsUnmaps(t *testing.T) {
+	dir, err := ioutil.TempDir("", "view-unmap-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := Config{}
+	// This ensures that each segment can only hold one record.
+	c.Segment.MaxIndexBytes = entWidth
+	c.Segment.MaxOpenSegments = 2
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+	appendRecords(t, log, 20)
+
+	// Segments are closed and opened again over and over, only the open ones
+	// keep their store and indexes mapped.
+	for i := 0; i < 10; i++ {
+		for off := uint64(0); off < 20; off++ {
+			_, err := log.Read(off)
+			require.NoError(t, err)
+		}
+		require.LessOrEqual(t, mappings(t, dir), 3*c.Segment.MaxOpenSegments+2)
+	}
+	require.NoError(t, log.Close())
+	require.Equal(t, 0, mappings(t, dir), "Closing the log should unmap every file.")
+}
+
+func TestOpenSealedSegmentsReadOnly(t *testing.T) {
+	dir, err := ioutil.TempDir("", "view-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := Config{}
+	c.Segment.MaxIndexBytes = entWidth
+	c.Segment.MaxOpenSegments = 1
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+	appendRecords(t, log, 3)
+	require.NoError(t, log.Close())
+
+	modTimes := func() map[string]time.Time {
+		times := make(map[string]time.Time)
+		for off := 0; off < 3; off++ {
+			for _, ext := range segmentExts {
+				info, err := os.Stat(path.Join(dir, fmt.Sprintf("%d%s", off, ext)))
+				require.NoError(t, err)
+				times[info.Name()] = info.ModTime()
+			}
+		}
+		return times
+	}
+	before := modTimes()
+
+	// Sealed segments are opened without writing to their files.
+	log, err = NewLog(dir, c)
+	require.NoError(t, err)
+	require.Equal(t, before, modTimes())
+	for off := uint64(0); off < 3; off++ {
+		read, err := log.Read(off)
+		require.NoError(t, err)
+		require.Equal(t, off, read.Offset)
+	}
+	require.NoError(t, log.Close())
+}
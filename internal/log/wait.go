@@ -0,0 +1,60 @@
+package log
+
+import (
+	"context"
+
+	api "github.com/AYM1607/proglog/api/v1"
+)
+
+// Wait blocks until a record at or after off can be read, or the log moved
+// past it, or ctx is done. It returns ctx's error in the latter case.
+func (l *Log) Wait(ctx context.Context, off uint64) error {
+	for {
+		// The channel is taken before checking the log so an append between the
+		// check and the wait still wakes it up.
+		appended := l.appendedChan()
+
+		l.mu.RLock()
+		next := l.activeSegment.nextOffset
+		l.mu.RUnlock()
+		if off < next && l.readable(off) {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-appended:
+		}
+	}
+}
+
+// readable reports whether reading off doesn't fail because there are no
+// records at or after it. Compaction can remove the last records before the
+// next offset, those only show up again once more records are appended.
+func (l *Log) readable(off uint64) bool {
+	_, err := l.Read(off)
+	e, ok := err.(api.ErrOffsetOutOfRange)
+	return !ok || e.Truncated()
+}
+
+// appendedChan returns a channel that is closed the next time records are committed.
+func (l *Log) appendedChan() <-chan struct{} {
+	l.appendedMu.Lock()
+	defer l.appendedMu.Unlock()
+	if l.appended == nil {
+		l.appended = make(chan struct{})
+	}
+	return l.appended
+}
+
+// notifyAppended wakes up everyone waiting for new records.
+func (l *Log) notifyAppended() {
+	l.appendedMu.Lock()
+	defer l.appendedMu.Unlock()
+	// The channel is only made when there's someone waiting on it.
+	if l.appended != nil {
+		close(l.appended)
+		l.appended = nil
+	}
+}
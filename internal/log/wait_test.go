@@ -0,0 +1,80 @@
+package log
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	api "github.com/AYM1607/proglog/api/v1"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWait(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wait-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	log, err := NewLog(dir, Config{})
+	require.NoError(t, err)
+	defer log.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	require.Equal(t, context.DeadlineExceeded, log.Wait(ctx, 0), "Waiting should stop when the context is done.")
+
+	waited := make(chan error)
+	go func() {
+		waited <- log.Wait(context.Background(), 1)
+	}()
+
+	appendRecords(t, log, 1)
+	select {
+	case <-waited:
+		t.Fatal("Waiting should block until the offset is appended.")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	appendRecords(t, log, 1)
+	select {
+	case err := <-waited:
+		require.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("Appending the offset should wake up the waiters.")
+	}
+
+	// Offsets that were already appended don't block.
+	require.NoError(t, log.Wait(context.Background(), 0))
+}
+
+func TestWaitCompactedTail(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wait-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := Config{}
+	c.Segment.MaxIndexBytes = entWidth * 2
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+	defer log.Close()
+
+	// The tombstone at the end of the sealed segment is compacted away.
+	for _, record := range []*api.Record{
+		{Value: []byte("no key")},
+		{Key: []byte("key")},
+	} {
+		_, err = log.Append(record)
+		require.NoError(t, err)
+	}
+	require.NoError(t, log.Compact())
+	_, err = log.Read(1)
+	require.IsType(t, api.ErrOffsetOutOfRange{}, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	require.Equal(t, context.DeadlineExceeded, log.Wait(ctx, 1), "Compacted offsets shouldn't wake up the waiters.")
+
+	appendRecords(t, log, 1)
+	require.NoError(t, log.Wait(context.Background(), 1))
+}
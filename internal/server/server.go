@@ -93,6 +93,22 @@ func newgrpcServer(config *Config) (srv *grpcServer, err error) {
 	return srv, nil
 }
 
+func (s *grpcServer) RegisterProducer(ctx context.Context, req *api.RegisterProducerRequest) (
+	*api.RegisterProducerResponse, error) {
+	if err := s.Authorizer.Authorize(
+		subject(ctx),
+		objectWildCard,
+		produceAction,
+	); err != nil {
+		return nil, err
+	}
+	id, err := s.CommitLog.RegisterProducer()
+	if err != nil {
+		return nil, err
+	}
+	return &api.RegisterProducerResponse{ProducerId: id}, nil
+}
+
 func (s *grpcServer) Produce(ctx context.Context, req *api.ProduceRequest) (
 	*api.ProduceResponse, error) {
 	if err := s.Authorizer.Authorize(
@@ -102,13 +118,38 @@ func (s *grpcServer) Produce(ctx context.Context, req *api.ProduceRequest) (
 	); err != nil {
 		return nil, err
 	}
-	offset, err := s.CommitLog.Append(req.Record)
+	var offset uint64
+	var err error
+	if req.ExpectedOffset != nil {
+		offset, err = s.CommitLog.AppendIf(*req.ExpectedOffset, req.Record)
+	} else {
+		offset, err = s.CommitLog.Append(req.Record)
+	}
 	if err != nil {
 		return nil, err
 	}
 	return &api.ProduceResponse{Offset: offset}, nil
 }
 
+func (s *grpcServer) ProduceBatch(ctx context.Context, req *api.ProduceBatchRequest) (
+	*api.ProduceBatchResponse, error) {
+	if err := s.Authorizer.Authorize(
+		subject(ctx),
+		objectWildCard,
+		produceAction,
+	); err != nil {
+		return nil, err
+	}
+	offset, err := s.CommitLog.AppendBatch(req.Records)
+	if err != nil {
+		return nil, err
+	}
+	return &api.ProduceBatchResponse{
+		BaseOffset: offset,
+		Count:      uint64(len(req.Records)),
+	}, nil
+}
+
 func (s *grpcServer) Consume(ctx context.Context, req *api.ConsumeRequest) (
 	*api.ConsumeResponse, error) {
 	if err := s.Authorizer.Authorize(
@@ -118,13 +159,45 @@ func (s *grpcServer) Consume(ctx context.Context, req *api.ConsumeRequest) (
 	); err != nil {
 		return nil, err
 	}
-	record, err := s.CommitLog.Read(req.Offset)
+	off := req.Offset
+	if req.StartTime != 0 {
+		var err error
+		off, err = s.CommitLog.OffsetForTime(time.Unix(0, req.StartTime))
+		if err != nil {
+			return nil, err
+		}
+	}
+	record, err := s.CommitLog.Read(off)
+	if e, ok := err.(api.ErrOffsetOutOfRange); ok {
+		if off, ok = resetOffset(e, req.OffsetReset); ok {
+			record, err = s.CommitLog.Read(off)
+		}
+	}
 	if err != nil {
 		return nil, err
 	}
 	return &api.ConsumeResponse{Record: record}, nil
 }
 
+// resetOffset returns the offset to continue from, according to the policy,
+// when the requested one was removed from the log. The returned bool is false
+// if the offset isn't to be reset.
+func resetOffset(
+	err api.ErrOffsetOutOfRange,
+	policy api.ConsumeRequest_OffsetReset,
+) (uint64, bool) {
+	if !err.Truncated() {
+		return 0, false
+	}
+	switch policy {
+	case api.ConsumeRequest_EARLIEST:
+		return err.Lowest, true
+	case api.ConsumeRequest_LATEST:
+		return err.Highest + 1, true
+	}
+	return 0, false
+}
+
 func (s *grpcServer) ProduceStream(
 	stream api.Log_ProduceStreamServer,
 ) error {
@@ -147,17 +220,39 @@ func (s *grpcServer) ConsumeStream(
 	req *api.ConsumeRequest,
 	stream api.Log_ConsumeStreamServer,
 ) error {
+	// Resolve the start time once, the stream then moves forward by offset.
+	if req.StartTime != 0 {
+		off, err := s.CommitLog.OffsetForTime(time.Unix(0, req.StartTime))
+		if err != nil {
+			return err
+		}
+		req.Offset = off
+		req.StartTime = 0
+	}
 	for {
 		select {
 		case <-stream.Context().Done():
 			return nil
 		default:
 			res, err := s.Consume(stream.Context(), req)
-			switch err.(type) {
+			switch e := err.(type) {
 			case nil:
 			case api.ErrOffsetOutOfRange:
-				// This is supposed to hold off until there's more data appended to the log.
-				// The code could return this error for records that have been deleted and it'd be stuck forever.
+				// Records that were removed from the log will never show up. Consume
+				// already reset the offset unless the policy is to fail, try again
+				// in case the log was truncated in the meantime.
+				if e.Truncated() {
+					if req.OffsetReset == api.ConsumeRequest_FAIL {
+						return err
+					}
+					continue
+				}
+				// Consume could have reset the offset, hold off from there until
+				// there's more data appended to the log.
+				req.Offset = e.Offset
+				if err = s.CommitLog.Wait(stream.Context(), req.Offset); err != nil {
+					return nil
+				}
 				continue
 			default:
 				return err
@@ -165,7 +260,8 @@ func (s *grpcServer) ConsumeStream(
 			if err = stream.Send(res); err != nil {
 				return err
 			}
-			req.Offset += 1
+			// Compaction can leave gaps in the log, continue after the record that was read.
+			req.Offset = res.Record.Offset + 1
 		}
 	}
 }
@@ -196,7 +292,12 @@ type subjectContextKey struct{}
 
 type CommitLog interface {
 	Append(*api.Record) (uint64, error)
+	AppendIf(uint64, *api.Record) (uint64, error)
+	AppendBatch([]*api.Record) (uint64, error)
+	RegisterProducer() (uint64, error)
 	Read(uint64) (*api.Record, error)
+	OffsetForTime(time.Time) (uint64, error)
+	Wait(context.Context, uint64) error
 }
 
 type Authorizer interface {
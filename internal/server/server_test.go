@@ -16,6 +16,7 @@ import (
 	"github.com/stretchr/testify/require"
 	"go.opencensus.io/examples/exporter"
 	"go.uber.org/zap"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials"
@@ -45,7 +46,10 @@ func TestServer(t *testing.T) {
 	){
 		"produce/consume a message to/from the log succeeds": testProduceConsume,
 		"produce/consume stream succeeds":                    testProduceConsumeStream,
+		"produce a batch succeeds":                           testProduceBatch,
+		"consume stream waits for new records":               testConsumeStreamWait,
 		"consume past a log boundary fails":                  testConsumePastBoundary,
+		"consume from a start time succeeds":                 testConsumeStartTime,
 		"unauthorized fails":                                 testUnauthorized,
 	} {
 		t.Run(scenario, func(t *testing.T) {
@@ -59,6 +63,145 @@ func TestServer(t *testing.T) {
 	}
 }
 
+func TestServerCorruptRecord(t *testing.T) {
+	client, _, _, teardown := setupTest(t, func(c *Config) {
+		c.CommitLog = corruptLog{c.CommitLog}
+	})
+	defer teardown()
+
+	consume, err := client.Consume(context.Background(), &api.ConsumeRequest{
+		Offset: 0,
+	})
+	require.Nil(t, consume, "consume should be nil")
+	require.Equal(t, codes.DataLoss, status.Code(err),
+		"consuming a corrupt record should fail with data loss")
+}
+
+func TestServerConditionalProduce(t *testing.T) {
+	client, _, _, teardown := setupTest(t, nil)
+	defer teardown()
+	ctx := context.Background()
+
+	expected := uint64(0)
+	produce, err := client.Produce(ctx, &api.ProduceRequest{
+		Record:         &api.Record{Value: []byte("hello world")},
+		ExpectedOffset: &expected,
+	})
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), produce.Offset)
+
+	_, err = client.Produce(ctx, &api.ProduceRequest{
+		Record:         &api.Record{Value: []byte("hello world")},
+		ExpectedOffset: &expected,
+	})
+	require.Equal(t, codes.FailedPrecondition, status.Code(err),
+		"producing at a taken offset should fail the precondition")
+}
+
+func TestServerIdempotentProduce(t *testing.T) {
+	client, _, _, teardown := setupTest(t, nil)
+	defer teardown()
+	ctx := context.Background()
+
+	register, err := client.RegisterProducer(ctx, &api.RegisterProducerRequest{})
+	require.NoError(t, err)
+	record := &api.Record{
+		Value:      []byte("hello world"),
+		ProducerId: register.ProducerId,
+	}
+	for i := 0; i < 2; i++ {
+		produce, err := client.Produce(ctx, &api.ProduceRequest{Record: record})
+		require.NoError(t, err)
+		require.Equal(t, uint64(0), produce.Offset, "a retry should get the original offset")
+	}
+
+	record.Sequence = 2
+	_, err = client.Produce(ctx, &api.ProduceRequest{Record: record})
+	require.Equal(t, codes.FailedPrecondition, status.Code(err),
+		"producing after a gap in the sequence should fail the precondition")
+}
+
+func TestServerOffsetReset(t *testing.T) {
+	dir, err := ioutil.TempDir("", "server-reset-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := log.Config{}
+	// This ensures that each segment can only hold one record.
+	c.Segment.MaxIndexBytes = 16
+	clog, err := log.NewLog(dir, c)
+	require.NoError(t, err)
+	defer clog.Close()
+
+	client, _, _, teardown := setupTest(t, func(c *Config) {
+		c.CommitLog = clog
+	})
+	defer teardown()
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		_, err := client.Produce(ctx, &api.ProduceRequest{
+			Record: &api.Record{Value: []byte("hello world")},
+		})
+		require.NoError(t, err)
+	}
+	require.NoError(t, clog.Truncate(2))
+
+	_, err = client.Consume(ctx, &api.ConsumeRequest{Offset: 0})
+	require.Equal(t, codes.NotFound, status.Code(err), "consuming a removed offset fails by default")
+	var info *errdetails.ErrorInfo
+	for _, d := range status.Convert(err).Details() {
+		if d, ok := d.(*errdetails.ErrorInfo); ok {
+			info = d
+		}
+	}
+	require.NotNil(t, info, "the error should carry the log's range")
+	require.Equal(t, "2", info.Metadata["lowest"])
+	require.Equal(t, "2", info.Metadata["highest"])
+
+	consume, err := client.Consume(ctx, &api.ConsumeRequest{
+		Offset:      0,
+		OffsetReset: api.ConsumeRequest_EARLIEST,
+	})
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), consume.Record.Offset)
+
+	_, err = client.Consume(ctx, &api.ConsumeRequest{
+		Offset:      0,
+		OffsetReset: api.ConsumeRequest_LATEST,
+	})
+	require.Equal(t, codes.NotFound, status.Code(err), "there's no record at the latest offset yet")
+
+	stream, err := client.ConsumeStream(ctx, &api.ConsumeRequest{Offset: 0})
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	require.Equal(t, codes.NotFound, status.Code(err), "streaming a removed offset fails by default")
+
+	stream, err = client.ConsumeStream(ctx, &api.ConsumeRequest{
+		Offset:      0,
+		OffsetReset: api.ConsumeRequest_LATEST,
+	})
+	require.NoError(t, err)
+	// The stream is waiting for the next record by the time it's produced.
+	time.Sleep(10 * time.Millisecond)
+	produce, err := client.Produce(ctx, &api.ProduceRequest{
+		Record: &api.Record{Value: []byte("hello world")},
+	})
+	require.NoError(t, err)
+	res, err := stream.Recv()
+	require.NoError(t, err)
+	require.Equal(t, produce.Offset, res.Record.Offset)
+}
+
+// corruptLog is a commit log whose records always fail their integrity check.
+type corruptLog struct {
+	CommitLog
+}
+
+func (corruptLog) Read(off uint64) (*api.Record, error) {
+	return nil, api.ErrCorruptRecord{Offset: off}
+}
+
 func setupTest(t *testing.T, fn func(*Config)) (
 	rootClient api.LogClient,
 	nobodyClient api.LogClient,
@@ -116,9 +259,9 @@ func setupTest(t *testing.T, fn func(*Config)) (
 	require.NoError(t, err)
 	serverCreds := credentials.NewTLS(serverTLSConfig)
 
+	// The teardown removes the log along with its directory.
 	dir, err := ioutil.TempDir("", "server-test")
 	require.NoError(t, err)
-	defer os.RemoveAll(dir)
 
 	clog, err := log.NewLog(dir, log.Config{})
 	require.NoError(t, err)
@@ -178,7 +321,10 @@ func testProduceConsume(t *testing.T, client, _ api.LogClient, config *Config) {
 	ctx := context.Background()
 
 	want := &api.Record{
-		Value: []byte("hello world"),
+		Value:     []byte("hello world"),
+		Key:       []byte("greeting"),
+		Headers:   []*api.Header{{Key: "content-type", Value: []byte("text/plain")}},
+		Timestamp: time.Now().UnixNano(),
 	}
 
 	produce, err := client.Produce(
@@ -196,6 +342,34 @@ func testProduceConsume(t *testing.T, client, _ api.LogClient, config *Config) {
 	require.NoError(t, err)
 	require.Equal(t, produce.Offset, consume.Record.Offset)
 	require.Equal(t, want.Value, consume.Record.Value)
+	require.Equal(t, want.Key, consume.Record.Key)
+	require.Equal(t, want.Timestamp, consume.Record.Timestamp)
+	require.Len(t, consume.Record.Headers, 1)
+	require.Equal(t, want.Headers[0].Key, consume.Record.Headers[0].Key)
+	require.Equal(t, want.Headers[0].Value, consume.Record.Headers[0].Value)
+}
+
+func testProduceBatch(t *testing.T, client, _ api.LogClient, config *Config) {
+	ctx := context.Background()
+
+	records := []*api.Record{
+		{Value: []byte("first message")},
+		{Value: []byte("second message")},
+	}
+	produce, err := client.ProduceBatch(ctx, &api.ProduceBatchRequest{
+		Records: records,
+	})
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), produce.BaseOffset)
+	require.Equal(t, uint64(len(records)), produce.Count)
+
+	for i, record := range records {
+		consume, err := client.Consume(ctx, &api.ConsumeRequest{
+			Offset: produce.BaseOffset + uint64(i),
+		})
+		require.NoError(t, err)
+		require.Equal(t, record.Value, consume.Record.Value)
+	}
 }
 
 func testConsumePastBoundary(
@@ -221,6 +395,42 @@ func testConsumePastBoundary(
 	require.Equal(t, want, got)
 }
 
+func testConsumeStartTime(
+	t *testing.T,
+	client, _ api.LogClient,
+	config *Config,
+) {
+	ctx := context.Background()
+
+	_, err := client.Produce(ctx, &api.ProduceRequest{
+		Record: &api.Record{Value: []byte("before")},
+	})
+	require.NoError(t, err)
+
+	// Make sure the next record is appended strictly later.
+	time.Sleep(time.Millisecond)
+	start := time.Now()
+	produce, err := client.Produce(ctx, &api.ProduceRequest{
+		Record: &api.Record{Value: []byte("after")},
+	})
+	require.NoError(t, err)
+
+	consume, err := client.Consume(ctx, &api.ConsumeRequest{
+		StartTime: start.UnixNano(),
+	})
+	require.NoError(t, err)
+	require.Equal(t, produce.Offset, consume.Record.Offset)
+	require.Equal(t, []byte("after"), consume.Record.Value)
+
+	stream, err := client.ConsumeStream(ctx, &api.ConsumeRequest{
+		StartTime: start.UnixNano(),
+	})
+	require.NoError(t, err)
+	res, err := stream.Recv()
+	require.NoError(t, err)
+	require.Equal(t, produce.Offset, res.Record.Offset)
+}
+
 func testProduceConsumeStream(
 	t *testing.T,
 	client, _ api.LogClient,
@@ -231,9 +441,11 @@ func testProduceConsumeStream(
 	records := []*api.Record{{
 		Value:  []byte("first message"),
 		Offset: 0,
+		Key:    []byte("first"),
 	}, {
-		Value:  []byte("second message"),
-		Offset: 1,
+		Value:   []byte("second message"),
+		Offset:  1,
+		Headers: []*api.Header{{Key: "attempt", Value: []byte("1")}},
 	}}
 
 	// Test Produce Stream.
@@ -267,15 +479,41 @@ func testProduceConsumeStream(
 		for _, record := range records {
 			res, err := stream.Recv()
 			require.NoError(t, err)
-			// A record literal must be used otherwise the comparison fails.
-			require.Equal(t, &api.Record{
-				Value:  record.Value,
-				Offset: record.Offset,
-			}, res.Record)
+			require.Equal(t, record.Value, res.Record.Value)
+			require.Equal(t, record.Offset, res.Record.Offset)
+			require.Equal(t, record.Key, res.Record.Key)
+			require.Equal(t, len(record.Headers), len(res.Record.Headers))
+			for i, h := range record.Headers {
+				require.Equal(t, h.Key, res.Record.Headers[i].Key)
+				require.Equal(t, h.Value, res.Record.Headers[i].Value)
+			}
 		}
 	}
 }
 
+func testConsumeStreamWait(
+	t *testing.T,
+	client, _ api.LogClient,
+	config *Config,
+) {
+	ctx := context.Background()
+
+	stream, err := client.ConsumeStream(ctx, &api.ConsumeRequest{Offset: 0})
+	require.NoError(t, err)
+
+	// The stream is waiting on an empty log by the time the record is produced.
+	time.Sleep(10 * time.Millisecond)
+	produce, err := client.Produce(ctx, &api.ProduceRequest{
+		Record: &api.Record{Value: []byte("hello world")},
+	})
+	require.NoError(t, err)
+
+	res, err := stream.Recv()
+	require.NoError(t, err)
+	require.Equal(t, produce.Offset, res.Record.Offset)
+	require.Equal(t, []byte("hello world"), res.Record.Value)
+}
+
 func testUnauthorized(
 	t *testing.T,
 	_,